@@ -1,10 +1,13 @@
 package bits
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"time"
 )
 
 const (
@@ -17,6 +20,26 @@ var (
 	ErrNoSuchKey = errors.New("no such key")
 )
 
+//TransientError is an error that a store returns when a request failed for
+//a reason that may pass, e.g: a dropped connection or a server that is
+//overloaded. Trying again later may succeed while a permanent error, e.g:
+//a denied request, will keep failing
+type TransientError interface {
+	Transient() bool
+	error
+}
+
+//IsTransient returns whether 'err', or any error it wraps, is a transient
+//error. Errors that don't describe themselves are considered permanent
+func IsTransient(err error) bool {
+	var terr TransientError
+	if errors.As(err, &terr) {
+		return terr.Transient()
+	}
+
+	return false
+}
+
 //KeyWriter is called when a key is outputted
 type KeyWriter interface {
 	Write(k K) error
@@ -28,6 +51,23 @@ type KeyReader interface {
 	Read() (K, error)
 }
 
+//ChunkKeyWriter is a key writer that also records something about the
+//plaintext of each chunk, e.g: its size. Put calls WriteChunk instead of
+//Write if the key writer implements it
+type ChunkKeyWriter interface {
+	WriteChunk(k K, chunk []byte) error
+	KeyWriter
+}
+
+//ChunkVerifier is a key reader that can verify the plaintext chunks of the
+//keys it returns, e.g: against their sizes. Get calls VerifyChunk with each
+//chunk before it is written and Verify after the last chunk was written
+type ChunkVerifier interface {
+	VerifyChunk(k K, chunk []byte) error
+	Verify() error
+	KeyReader
+}
+
 //ChunkReader allows reading one piece of input at a time
 type ChunkReader interface {
 	Read() ([]byte, error)
@@ -44,6 +84,30 @@ type KeyIndex interface {
 	Has(k K) bool
 }
 
+//ProbabilisticIndex is a key index that can report keys it doesn't hold,
+//e.g: a bloom filter. Move confirms with the remote that it holds a key
+//before the key is skipped
+type ProbabilisticIndex interface {
+	FalsePositiveRate() float64
+	KeyIndex
+}
+
+//SyncedIndex is a key index that persists between moves and remembers when
+//it last held all keys of a remote. Move only lists the remote if the index
+//isn't fresh and writes the keys it moves to it, concurrently. Written keys
+//may be buffered until the index is flushed. A sync is started before the
+//remote is listed, once it is marked the index holds only the keys that were
+//written since, keys of chunks that were deleted from the remote are dropped.
+//The index records the gc epoch of the remote it was synced at, it is not
+//fresh once garbage was collected from the remote since
+type SyncedIndex interface {
+	Fresh(epoch []byte) bool
+	StartSync() error
+	MarkSynced(t time.Time, epoch []byte) error
+	Flush() error
+	KeyIndex
+}
+
 //Store holds chunks and is expected to have such a low latency that
 //checking existence before put call is economic per key
 type Store interface {
@@ -62,6 +126,229 @@ type RemoteStore interface {
 	Store
 }
 
+//MetaStore is implemented by stores that can hold small named values about
+//the store itself next to its chunks, e.g: the mode its keys were hashed with
+type MetaStore interface {
+
+	//returns os.ErrNotExist if no value was put under the name
+	GetMeta(name string) (v []byte, err error)
+
+	//overwrites any value that was put under the name before
+	PutMeta(name string, v []byte) error
+	Store
+}
+
+//HasStore is implemented by stores that can check whether they hold a chunk
+//without getting it, e.g: with a HEAD request
+type HasStore interface {
+	Has(k K) (ok bool, err error)
+	Store
+}
+
+//DeleteStore is implemented by stores from which chunks can be removed,
+//e.g: by garbage collection. Deleting a chunk that doesn't exist is no error
+type DeleteStore interface {
+	Delete(k K) error
+	Store
+}
+
+//ChunkInfo describes a chunk that a store holds
+type ChunkInfo struct {
+	K    K
+	Size int64
+
+	//when the chunk was last put, zero if the store doesn't record it
+	ModTime time.Time
+}
+
+//ListStore is implemented by stores that can enumerate the chunks they
+//hold. List calls 'fn' for each chunk and stops at the first error, the
+//store must not be modified from within 'fn'
+type ListStore interface {
+	List(fn func(info ChunkInfo) error) error
+	Store
+}
+
+//Latency is the class of latency of a store, stores of a lower class are
+//asked for chunks first
+type Latency int
+
+const (
+	//LatencyMemory is the class of stores that hold chunks in memory
+	LatencyMemory Latency = iota
+
+	//LatencyDisk is the class of stores that hold chunks on a local disk
+	LatencyDisk
+
+	//LatencyNetwork is the class of stores that are reached over a network
+	LatencyNetwork
+)
+
+//LatencyStore is implemented by stores that declare their latency class
+type LatencyStore interface {
+	Latency() Latency
+	Store
+}
+
+//ContextStore is implemented by stores whose operations can take long enough
+//that they should be aborted when a context is cancelled or its deadline
+//is exceeded, e.g: stores that perform network requests
+type ContextStore interface {
+	PutContext(ctx context.Context, k K, chunk []byte) error
+	GetContext(ctx context.Context, k K) (chunk []byte, err error)
+	Store
+}
+
+//ContextRemoteStore is a remote store that can abort indexing when a context
+//is cancelled or its deadline is exceeded
+type ContextRemoteStore interface {
+	IndexContext(ctx context.Context, kw KeyWriter) error
+	RemoteStore
+}
+
+//ContextHasStore is a store that can abort checking whether it holds a chunk
+//when a context is cancelled or its deadline is exceeded
+type ContextHasStore interface {
+	HasContext(ctx context.Context, k K) (ok bool, err error)
+	HasStore
+}
+
+//ContextMetaStore is a meta store that can abort getting and putting values
+//when a context is cancelled or its deadline is exceeded
+type ContextMetaStore interface {
+	PutMetaContext(ctx context.Context, name string, v []byte) error
+	GetMetaContext(ctx context.Context, name string) (v []byte, err error)
+	MetaStore
+}
+
+//ContextDeleteStore is a delete store that can abort removing a chunk when
+//a context is cancelled or its deadline is exceeded
+type ContextDeleteStore interface {
+	DeleteContext(ctx context.Context, k K) error
+	DeleteStore
+}
+
+//ContextListStore is a list store that can abort enumerating its chunks
+//when a context is cancelled or its deadline is exceeded
+type ContextListStore interface {
+	ListContext(ctx context.Context, fn func(info ChunkInfo) error) error
+	ListStore
+}
+
+//putContext puts a chunk into store 's', aborting when the context is done
+//if the store supports it
+func putContext(ctx context.Context, s Store, k K, chunk []byte) error {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.PutContext(ctx, k, chunk)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.Put(k, chunk)
+}
+
+//getContext gets a chunk from store 's', aborting when the context is done
+//if the store supports it
+func getContext(ctx context.Context, s Store, k K) (chunk []byte, err error) {
+	if cs, ok := s.(ContextStore); ok {
+		return cs.GetContext(ctx, k)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.Get(k)
+}
+
+//hasContext checks whether store 's' holds a chunk, aborting when the context
+//is done if the store supports it
+func hasContext(ctx context.Context, s HasStore, k K) (ok bool, err error) {
+	if cs, ok := s.(ContextHasStore); ok {
+		return cs.HasContext(ctx, k)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return s.Has(k)
+}
+
+//deleteContext removes a chunk from store 's', aborting when the context is
+//done if the store supports it
+func deleteContext(ctx context.Context, s DeleteStore, k K) error {
+	if cs, ok := s.(ContextDeleteStore); ok {
+		return cs.DeleteContext(ctx, k)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.Delete(k)
+}
+
+//listContext enumerates the chunks of store 's', aborting when the context
+//is done if the store supports it
+func listContext(ctx context.Context, s ListStore, fn func(info ChunkInfo) error) error {
+	if cs, ok := s.(ContextListStore); ok {
+		return cs.ListContext(ctx, fn)
+	}
+
+	return s.List(func(info ChunkInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		return fn(info)
+	})
+}
+
+//indexContext indexes remote store 'r', aborting when the context is done
+//if the remote supports it
+func indexContext(ctx context.Context, r RemoteStore, kw KeyWriter) error {
+	if cr, ok := r.(ContextRemoteStore); ok {
+		return cr.IndexContext(ctx, kw)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return r.Index(kw)
+}
+
+//putMetaContext puts a named value into meta store 'ms', aborting when the
+//context is done if the store supports it
+func putMetaContext(ctx context.Context, ms MetaStore, name string, v []byte) error {
+	if cms, ok := ms.(ContextMetaStore); ok {
+		return cms.PutMetaContext(ctx, name, v)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return ms.PutMeta(name, v)
+}
+
+//getMetaContext gets a named value from meta store 'ms', aborting when the
+//context is done if the store supports it
+func getMetaContext(ctx context.Context, ms MetaStore, name string) (v []byte, err error) {
+	if cms, ok := ms.(ContextMetaStore); ok {
+		return cms.GetMetaContext(ctx, name)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return ms.GetMeta(name)
+}
+
 //KeyHash turns a arbitrary sized chunk into content-based key
 type KeyHash func([]byte) K
 
@@ -95,14 +382,77 @@ func DecodeKey(b []byte) (k K, err error) {
 //of stores for various purposes thoughout our code
 type StoreMap map[string]Store
 
+//srcRoles are the roles of stores in the order they are asked for chunks
+//when their latency is of the same class
+var srcRoles = map[string]int{"local": 0, "remote": 1}
+
+//latencyOf returns the latency class of the store with name 'name', stores
+//that don't declare it are assumed to be on disk if they play the 'local'
+//role and on the network otherwise
+func latencyOf(name string, s Store) Latency {
+	if ls, ok := s.(LatencyStore); ok {
+		return ls.Latency()
+	}
+
+	if name == "local" {
+		return LatencyDisk
+	}
+
+	return LatencyNetwork
+}
+
 //GetSrcs returns an ordered list of stores for getting chunks
-//for the current store configuration. this can be a default or
-//explictely overwitten by the user
-//@TODO store by 'localness' or 'likelyhood of having chunks'
-func (sm StoreMap) GetSrcs() (stores []Store) {
-	for _, s := range sm {
-		stores = append(stores, s)
+//for the current store configuration. If names are given only those stores
+//are returned, in the order given. Otherwise stores with a lower latency
+//class are asked first, within a class the 'local' store is asked before
+//the 'remote' store and others follow in order of name
+func (sm StoreMap) GetSrcs(order ...string) (stores []Store) {
+	if len(order) > 0 {
+		seen := map[string]struct{}{}
+		for _, name := range order {
+			if _, ok := seen[name]; ok || sm[name] == nil {
+				continue
+			}
+
+			seen[name] = struct{}{}
+			stores = append(stores, sm[name])
+		}
+
+		return stores
+	}
+
+	names := []string{}
+	for name := range sm {
+		names = append(names, name)
 	}
+
+	rank := func(name string) int {
+		if r, ok := srcRoles[name]; ok {
+			return r
+		}
+
+		return len(srcRoles)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		li, lj := latencyOf(names[i], sm[names[i]]), latencyOf(names[j], sm[names[j]])
+		if li != lj {
+			return li < lj
+		}
+
+		if ri, rj := rank(names[i]), rank(names[j]); ri != rj {
+			return ri < rj
+		}
+
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		if sm[name] != nil {
+			stores = append(stores, sm[name])
+		}
+	}
+
 	return stores
 }
 
@@ -117,6 +467,12 @@ func (sm StoreMap) PutDst() (store Store, err error) {
 	return nil, fmt.Errorf("couldnt get store")
 }
 
+//GCStore returns the store from which garbage is collected in the current
+//store configuration, this is the store that chunks are put into
+func (sm StoreMap) GCStore() (store Store, err error) {
+	return sm.PutDst()
+}
+
 //MoveSrc returns a store from which chunks will be moved from
 //in the current store configuration. this can either be the default
 //or the store overwritten by user configuration
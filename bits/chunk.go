@@ -0,0 +1,194 @@
+package bits
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"time"
+)
+
+//sealChunk compresses and encrypts plaintext chunk 'chunk' with key 'k' and
+//wraps it in an envelope for storage
+func sealChunk(conf Config, k K, chunk []byte) (sealed []byte, err error) {
+	compressed, c, err := compressChunk(conf.Compression, chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.AEAD.NonceSize() != cipherNonceSizes[conf.cipher()] {
+		return nil, fmt.Errorf("nonce size of the aead doesn't match cipher %d", conf.cipher())
+	}
+
+	header := newEnvelopeHeader(conf.cipher(), c, conf.NonceHash != nil)
+	sealed = make([]byte, len(header)+conf.AEAD.NonceSize(), len(header)+conf.AEAD.NonceSize()+len(compressed)+conf.AEAD.Overhead())
+	copy(sealed, header)
+	nonce := sealed[len(header):]
+	if conf.NonceHash != nil {
+		if n := copy(nonce, conf.NonceHash(header, compressed)); n != len(nonce) {
+			return nil, fmt.Errorf("nonce hash returned %d bytes while the cipher requires %d", n, len(nonce))
+		}
+	} else if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	return conf.AEAD.Seal(sealed, nonce, compressed, header), nil
+}
+
+//openChunk authenticates and decrypts a chunk that was sealed for storage
+//and decompresses it if necessary. Chunks that were stored before envelopes
+//were introduced are read as well, envelopes of an unsupported version or
+//cipher are reported as such
+func openChunk(conf Config, k K, sealed []byte) (chunk []byte, err error) {
+	env, err := ParseEnvelope(sealed)
+	if err == ErrNoEnvelope {
+		return openLegacyChunk(conf, sealed)
+	} else if err != nil {
+		//the nonce of a legacy chunk may start with the magic bytes by chance,
+		//such a chunk only opens if it authenticates
+		if chunk, lerr := openLegacyChunk(conf, sealed); lerr == nil {
+			return chunk, nil
+		}
+
+		return nil, err
+	}
+
+	//the nonce of a legacy chunk may start with the magic bytes by chance
+	chunk, err = openEnvelope(conf, env)
+	if err != nil {
+		if chunk, lerr := openLegacyChunk(conf, sealed); lerr == nil {
+			return chunk, nil
+		}
+
+		return nil, err
+	}
+
+	return chunk, nil
+}
+
+//openEnvelope decrypts and decompresses the ciphertext of an envelope
+func openEnvelope(conf Config, env *Envelope) (chunk []byte, err error) {
+	if env.Cipher != conf.cipher() {
+		return nil, fmt.Errorf("chunk was encrypted with cipher %d while cipher %d is configured", env.Cipher, conf.cipher())
+	}
+
+	compressed, err := conf.AEAD.Open(nil, env.Nonce, env.Ciphertext, env.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompressChunk(env.Compression(), compressed)
+}
+
+//openLegacyChunk opens chunks that are stored without an envelope: the
+//nonce followed by the ciphertext
+func openLegacyChunk(conf Config, sealed []byte) (chunk []byte, err error) {
+	ns := conf.AEAD.NonceSize()
+	if len(sealed) < ns {
+		return nil, fmt.Errorf("encrypted chunk is too small (must be at least %d long): authentication failed", ns)
+	}
+
+	return conf.AEAD.Open(nil, sealed[:ns], sealed[ns:], nil)
+}
+
+//fetchChunk asks each of the stores in 'srcs' in order for the (sealed)
+//chunk with key 'k', it returns ErrNoSuchKey if none of the stores has it.
+//If 'hedge' is positive the next store is also asked when a store hasn't
+//answered within that duration, the first chunk that is returned is used
+func fetchChunk(ctx context.Context, srcs []Store, k K, hedge time.Duration) (sealed []byte, err error) {
+	if hedge > 0 {
+		sealed, err = fetchHedged(ctx, srcs, k, hedge)
+	} else {
+		sealed, err = fetchInOrder(ctx, srcs, k)
+	}
+
+	if err == nil {
+		return sealed, nil
+	}
+
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchKey
+	}
+
+	return nil, fmt.Errorf("failed to find key '%s': %w", k, err)
+}
+
+//fetchInOrder asks the next store only after the previous store failed
+func fetchInOrder(ctx context.Context, srcs []Store, k K) (sealed []byte, err error) {
+	err = os.ErrNotExist
+	for _, s := range srcs {
+		if s == nil {
+			continue
+		}
+
+		sealed, err = getContext(ctx, s, k)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			continue
+		}
+
+		return sealed, nil
+	}
+
+	return nil, err
+}
+
+//fetchHedged asks the next store when the previous store failed or hasn't
+//answered in time, requests that are still running are cancelled when a
+//chunk is returned
+func fetchHedged(ctx context.Context, srcs []Store, k K, hedge time.Duration) (sealed []byte, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		sealed []byte
+		err    error
+	}
+
+	ansCh := make(chan answer, len(srcs))
+	next, pending := 0, 0
+	ask := func() <-chan time.Time {
+		for ; next < len(srcs); next++ {
+			if s := srcs[next]; s != nil {
+				next++
+				pending++
+				go func() {
+					sealed, err := getContext(ctx, s, k)
+					ansCh <- answer{sealed, err}
+				}()
+
+				return time.After(hedge)
+			}
+		}
+
+		return nil
+	}
+
+	err = os.ErrNotExist
+	hedgeCh := ask()
+	for pending > 0 {
+		select {
+		case ans := <-ansCh:
+			pending--
+			if ans.err == nil {
+				return ans.sealed, nil
+			}
+
+			err = ans.err
+			if ctx.Err() != nil {
+				return nil, err
+			}
+
+			if pending == 0 {
+				hedgeCh = ask()
+			}
+		case <-hedgeCh:
+			hedgeCh = ask()
+		}
+	}
+
+	return nil, err
+}
@@ -8,10 +8,57 @@ import (
 )
 
 //SupportedChunkers holds identifiers for all supported stores
-var SupportedChunkers = []string{"rabin"}
+var SupportedChunkers = []string{"rabin", "fastcdc", "fixed"}
+
+//DefaultChunker is the chunker that is used when none is specified
+const DefaultChunker = "rabin"
+
+//ChunkerSettings configures chunkers that can be tuned, zero values
+//select the defaults
+type ChunkerSettings struct {
+
+	//size of each chunk of the fixed chunker
+	BlockSize int `json:"block_size,omitempty"`
+
+	//bounds and average of the chunk size of content-defined chunkers
+	MinSize int `json:"chunk_min,omitempty"`
+	AvgSize int `json:"chunk_avg,omitempty"`
+	MaxSize int `json:"chunk_max,omitempty"`
+}
+
+//WithDefaults returns the settings that apply to chunker 'ctype' with zero
+//values replaced by its defaults, settings that don't apply are cleared
+func (s ChunkerSettings) WithDefaults(ctype string) ChunkerSettings {
+	def := func(v, d int) int {
+		if v == 0 {
+			return d
+		}
+
+		return v
+	}
+
+	switch ctype {
+	case "rabin":
+		return ChunkerSettings{
+			MinSize: def(s.MinSize, DefaultRabinMinSize),
+			AvgSize: def(s.AvgSize, DefaultRabinAvgSize),
+			MaxSize: def(s.MaxSize, DefaultRabinMaxSize),
+		}
+	case "fastcdc":
+		return ChunkerSettings{
+			MinSize: def(s.MinSize, DefaultFastCDCMinSize),
+			AvgSize: def(s.AvgSize, DefaultFastCDCAvgSize),
+			MaxSize: def(s.MaxSize, DefaultFastCDCMaxSize),
+		}
+	case "fixed":
+		return ChunkerSettings{BlockSize: def(s.BlockSize, DefaultFixedBlockSize)}
+	default:
+		return s
+	}
+}
 
 //CreateChunker a store instance for any of the supported types
-func CreateChunker(ctype string, secret bits.Secret, input io.Reader) (cr bits.ChunkReader, err error) {
+func CreateChunker(ctype string, secret bits.Secret, input io.Reader, settings ChunkerSettings) (cr bits.ChunkReader, err error) {
 	sname := ""
 	for _, supported := range SupportedChunkers {
 		if supported == ctype {
@@ -25,16 +72,45 @@ func CreateChunker(ctype string, secret bits.Secret, input io.Reader) (cr bits.C
 	}
 
 	//maps factory args unto actual store creation
-	switch sname {
-	case "rabin":
-		return NewRabinChunker(input, secret.Pol()), nil
-	default:
-		return nil, fmt.Errorf("store type '%s' is not currently implemented", ctype)
+	settings = settings.WithDefaults(sname)
+	create := func(r io.Reader) (bits.ChunkReader, error) {
+		switch sname {
+		case "rabin":
+			return NewSizedRabinChunker(r, secret.Pol(), settings.MinSize, settings.AvgSize, settings.MaxSize)
+		case "fastcdc":
+			return NewFastCDCChunker(r, secret.Gear(), settings.MinSize, settings.AvgSize, settings.MaxSize)
+		case "fixed":
+			return NewFixedChunker(r, settings.BlockSize)
+		default:
+			return nil, fmt.Errorf("store type '%s' is not currently implemented", ctype)
+		}
+	}
+
+	//check the settings before chunking concurrently
+	cr, err = create(input)
+	if err != nil {
+		return nil, err
 	}
+
+	//random-access input is chunked concurrently
+	max := settings.MaxSize
+	if settings.BlockSize > max {
+		max = settings.BlockSize
+	}
+
+	if pc, ok := newParallelChunker(input, max, create); ok {
+		return pc, nil
+	}
+
+	return cr, nil
 }
 
 //CreateChunkWriter a store instance for any of the supported types
 func CreateChunkWriter(ctype string, w io.Writer) (cw bits.ChunkWriter, err error) {
+	if ctype == "" {
+		ctype = DefaultChunker
+	}
+
 	sname := ""
 	for _, supported := range SupportedChunkers {
 		if supported == ctype {
@@ -49,7 +125,7 @@ func CreateChunkWriter(ctype string, w io.Writer) (cw bits.ChunkWriter, err erro
 
 	//maps factory args unto actual store creation
 	switch sname {
-	case "rabin":
+	case "rabin", "fastcdc", "fixed":
 		return w, nil
 	default:
 		return nil, fmt.Errorf("store type '%s' is not currently implemented", ctype)
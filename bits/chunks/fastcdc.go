@@ -0,0 +1,103 @@
+package bitschunks
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	//DefaultFastCDCMinSize is the default minimal size of a FastCDC chunk
+	DefaultFastCDCMinSize = 512 * 1024
+
+	//DefaultFastCDCAvgSize is the default size that FastCDC chunks are
+	//normalized to
+	DefaultFastCDCAvgSize = 1024 * 1024
+
+	//DefaultFastCDCMaxSize is the default maximal size of a FastCDC chunk
+	DefaultFastCDCMaxSize = 8 * 1024 * 1024
+)
+
+//FastCDCChunker is a chunker that finds content-defined boundaries with a
+//gear hash as described by the FastCDC paper, including its normalized
+//chunking. It is much cheaper to compute than a rolling rabin checksum
+type FastCDCChunker struct {
+	input *lookahead
+	gear  [256]uint64
+
+	min   int
+	avg   int
+	max   int
+	maskS uint64 //harder to match, used before the average size is reached
+	maskL uint64 //easier to match, used after the average size is reached
+}
+
+//NewFastCDCChunker creates a FastCDC chunker that reads from 'r', chunks
+//are at least 'min' and at most 'max' bytes and normalized to 'avg' bytes.
+//The gear table should be unique to the secret, e.g: Secret.Gear()
+func NewFastCDCChunker(r io.Reader, gear [256]uint64, min, avg, max int) (c *FastCDCChunker, err error) {
+	if min < 64 || min >= avg || avg >= max {
+		return nil, fmt.Errorf("invalid chunk sizes, expected 64 <= min < avg < max, got: %d, %d, %d", min, avg, max)
+	}
+
+	abits := bits.Len(uint(avg)) - 1
+	return &FastCDCChunker{
+		input: newLookahead(r, max),
+		gear:  gear,
+		min:   min,
+		avg:   avg,
+		max:   max,
+		maskS: fastCDCMask(abits + 2),
+		maskL: fastCDCMask(abits - 2),
+	}, nil
+}
+
+//fastCDCMask returns a mask with the 'n' most significant bits set, these
+//bits of the gear hash depend on the most recent bytes of the input
+func fastCDCMask(n int) uint64 {
+	if n < 1 {
+		n = 1
+	}
+
+	return ^uint64(0) << uint(64-n)
+}
+
+//cut returns the length of the chunk at the start of 'data'
+func (c *FastCDCChunker) cut(data []byte) int {
+	if len(data) <= c.min {
+		return len(data)
+	}
+
+	n := len(data)
+	if n > c.max {
+		n = c.max
+	}
+
+	normal := c.avg
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + c.gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < n; i++ {
+		fp = (fp << 1) + c.gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
+
+//Read will return the next chunk for processing
+func (c *FastCDCChunker) Read() (chunk []byte, err error) {
+	return c.input.next(c.cut)
+}
@@ -0,0 +1,169 @@
+package bitschunks_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/chunks"
+)
+
+var secret = bits.Secret{
+	0x3D, 0xA3, 0x35, 0x8B, 0x4D, 0xC1, 0x73, 0x00, //polynomial
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, //random bytes
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+func randb(seed int64, size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+//fastCDCChunks returns the hashes of all chunks of 'data'
+func fastCDCChunks(t *testing.T, secret bits.Secret, data []byte, min, avg, max int) (sums [][32]byte) {
+	c, err := bitschunks.NewFastCDCChunker(bytes.NewReader(data), secret.Gear(), min, avg, max)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+
+	return readChunks(t, c, data, min, max)
+}
+
+//readChunks reads all chunks of 'data' from 'c', checks their size and
+//returns their hashes
+func readChunks(t *testing.T, c bits.ChunkReader, data []byte, min, max int) (sums [][32]byte) {
+	joined := bytes.NewBuffer(nil)
+	for {
+		chunk, err := c.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read chunk: %v", err)
+		}
+
+		if len(chunk) > max || (len(chunk) < min && joined.Len()+len(chunk) != len(data)) {
+			t.Errorf("chunk %d has size %d outside of bounds [%d, %d]", len(sums), len(chunk), min, max)
+		}
+
+		joined.Write(chunk)
+		sums = append(sums, sha256.Sum256(chunk))
+	}
+
+	if !bytes.Equal(joined.Bytes(), data) {
+		t.Fatalf("expected chunks to join into the input")
+	}
+
+	return sums
+}
+
+//shared returns how many chunks of 'b' also appear in 'a'
+func shared(a, b [][32]byte) (n int) {
+	seen := map[[32]byte]bool{}
+	for _, sum := range a {
+		seen[sum] = true
+	}
+
+	for _, sum := range b {
+		if seen[sum] {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestFastCDCBoundariesAreStable(t *testing.T) {
+	input := randb(1, 4*1024*1024)
+	min, avg, max := 2*1024, 8*1024, 64*1024
+	original := fastCDCChunks(t, secret, input, min, avg, max)
+	if len(original) < 4*1024*1024/max {
+		t.Fatalf("expected input to be split into many chunks, got: %d", len(original))
+	}
+
+	for _, c := range []struct {
+		name string
+		edit func(b []byte) []byte
+	}{
+		{"insert_front", func(b []byte) []byte { return append([]byte("foo"), b...) }},
+		{"insert_middle", func(b []byte) []byte {
+			return append(append(append([]byte{}, b[:len(b)/2]...), randb(2, 100)...), b[len(b)/2:]...)
+		}},
+		{"remove_middle", func(b []byte) []byte {
+			return append(append([]byte{}, b[:len(b)/3]...), b[len(b)/3+10:]...)
+		}},
+		{"append_end", func(b []byte) []byte { return append(append([]byte{}, b...), randb(3, 5000)...) }},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			edited := fastCDCChunks(t, secret, c.edit(input), min, avg, max)
+
+			//a local edit should only change the chunks around it
+			if n := shared(original, edited); n < len(original)-3 {
+				t.Errorf("expected at most 3 of %d chunks to change, %d changed", len(original), len(original)-n)
+			}
+		})
+	}
+
+	other := secret
+	other[31] = 0x01
+	if n := shared(original, fastCDCChunks(t, other, input, min, avg, max)); n > 1 {
+		t.Errorf("expected boundaries to depend on the secret, %d chunks are shared", n)
+	}
+}
+
+func TestFastCDCSizes(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		min      int
+		avg      int
+		max      int
+		input    []byte
+		expected int
+	}{
+		{"empty", 64, 128, 256, []byte{}, 0},
+		{"smaller_than_min", 64, 128, 256, randb(4, 10), 1},
+		{"zeros_cut_at_max", 64, 128, 256, make([]byte, 1024), 4},
+		{"invalid_sizes", 128, 64, 256, nil, -1},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := bitschunks.NewFastCDCChunker(bytes.NewReader(c.input), secret.Gear(), c.min, c.avg, c.max)
+			if c.expected < 0 {
+				if err == nil {
+					t.Errorf("expected invalid sizes to be refused")
+				}
+
+				return
+			}
+
+			sums := fastCDCChunks(t, secret, c.input, c.min, c.avg, c.max)
+			if len(sums) != c.expected {
+				t.Errorf("expected %d chunks, got: %d", c.expected, len(sums))
+			}
+		})
+	}
+}
+
+func BenchmarkChunkers(b *testing.B) {
+	input := randb(5, 32*1024*1024)
+	for _, name := range bitschunks.SupportedChunkers {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				cr, err := bitschunks.CreateChunker(name, secret, bytes.NewReader(input), bitschunks.ChunkerSettings{})
+				if err != nil {
+					b.Fatalf("failed to create chunker: %v", err)
+				}
+
+				for {
+					if _, err = cr.Read(); err != nil {
+						break
+					}
+				}
+			}
+		})
+	}
+}
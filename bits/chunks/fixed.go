@@ -0,0 +1,39 @@
+package bitschunks
+
+import (
+	"fmt"
+	"io"
+)
+
+//DefaultFixedBlockSize is the default size of chunks of the fixed chunker
+const DefaultFixedBlockSize = 1024 * 1024
+
+//FixedChunker splits input into chunks of equal size, only the last chunk
+//may be smaller. It suits input that is already block-aligned such as disk
+//images, where content-defined chunking only costs CPU time
+type FixedChunker struct {
+	r    io.Reader
+	size int
+}
+
+//NewFixedChunker creates a chunker that reads chunks of 'size' bytes from 'r'
+func NewFixedChunker(r io.Reader, size int) (c *FixedChunker, err error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid block size, expected at least 1 byte, got: %d", size)
+	}
+
+	return &FixedChunker{r: r, size: size}, nil
+}
+
+//Read will return the next chunk for processing
+func (c *FixedChunker) Read() (chunk []byte, err error) {
+	chunk = make([]byte, c.size)
+	n, err := io.ReadFull(c.r, chunk)
+	if err == io.ErrUnexpectedEOF {
+		return chunk[:n], nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
@@ -0,0 +1,65 @@
+package bitschunks_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits/chunks"
+)
+
+func TestFixedChunker(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		size     int
+		input    []byte
+		expected []int
+	}{
+		{"empty", 4096, []byte{}, nil},
+		{"aligned", 4096, randb(1, 3*4096), []int{4096, 4096, 4096}},
+		{"unaligned_tail", 4096, randb(1, 2*4096+10), []int{4096, 4096, 10}},
+		{"smaller_than_block", 4096, randb(1, 100), []int{100}},
+		{"invalid_size", -1, nil, nil},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cr, err := bitschunks.CreateChunker("fixed", secret, bytes.NewReader(c.input), bitschunks.ChunkerSettings{BlockSize: c.size})
+			if c.size < 1 {
+				if err == nil {
+					t.Errorf("expected invalid block size to be refused")
+				}
+
+				return
+			} else if err != nil {
+				t.Fatalf("failed to create chunker: %v", err)
+			}
+
+			sizes := []int{}
+			joined := bytes.NewBuffer(nil)
+			for {
+				chunk, err := cr.Read()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("failed to read chunk: %v", err)
+				}
+
+				sizes = append(sizes, len(chunk))
+				joined.Write(chunk)
+			}
+
+			if len(sizes) != len(c.expected) {
+				t.Fatalf("expected chunk sizes %v, got: %v", c.expected, sizes)
+			}
+
+			for i := range sizes {
+				if sizes[i] != c.expected[i] {
+					t.Errorf("expected chunk sizes %v, got: %v", c.expected, sizes)
+				}
+			}
+
+			if !bytes.Equal(joined.Bytes(), c.input) {
+				t.Errorf("expected chunks to join into the input")
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package bitschunks
+
+import (
+	"fmt"
+	"io"
+)
+
+//lookahead buffers input such that a content-defined chunker can look at
+//the bytes of a chunk of maximum size before deciding where to cut it
+type lookahead struct {
+	r   io.Reader
+	max int
+	buf []byte
+	pos int
+	end int
+	eof bool
+}
+
+//the buffer is allocated on first use such that creating a chunker is cheap
+func newLookahead(r io.Reader, max int) *lookahead {
+	return &lookahead{r: r, max: max}
+}
+
+//fill moves unread data to the front of the buffer and reads until at
+//least a chunk of maximum size is buffered or the input is exhausted
+func (l *lookahead) fill() error {
+	if l.buf == nil {
+		l.buf = make([]byte, 2*l.max)
+	}
+
+	if l.end-l.pos >= l.max || l.eof {
+		return nil
+	}
+
+	l.end = copy(l.buf, l.buf[l.pos:l.end])
+	l.pos = 0
+	for l.end < len(l.buf) {
+		n, err := l.r.Read(l.buf[l.end:])
+		l.end += n
+		if err == io.EOF {
+			l.eof = true
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//next returns a copy of the next chunk, function 'cut' is given the buffered
+//data and returns the length of the chunk at its start
+func (l *lookahead) next(cut func(data []byte) int) (chunk []byte, err error) {
+	err = l.fill()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %v", err)
+	}
+
+	if l.pos == l.end {
+		return nil, io.EOF
+	}
+
+	n := cut(l.buf[l.pos:l.end])
+	chunk = make([]byte, n)
+	copy(chunk, l.buf[l.pos:l.pos+n])
+	l.pos += n
+	return chunk, nil
+}
@@ -0,0 +1,244 @@
+package bitschunks
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+
+	"github.com/advanderveer/libchunk/bits"
+)
+
+//DefaultRegionSize is the minimal size of the regions that are chunked
+//concurrently by the parallel chunker
+const DefaultRegionSize = 64 * 1024 * 1024
+
+//ChunkerFunc creates a sequential chunker that reads from 'r'
+type ChunkerFunc func(r io.Reader) (bits.ChunkReader, error)
+
+//region of the input that is chunked by its own goroutine
+type region struct {
+	start int64
+	end   int64
+	resCh chan *regionResult
+}
+
+//regionResult holds the offsets at which a chunker that started at the
+//start of the region cut the input, up to and including the first cut
+//at or beyond the end of the region
+type regionResult struct {
+	cuts []int64
+	err  error
+}
+
+//ParallelChunker splits a random-access input into large regions that are
+//chunked concurrently. Since the boundaries of a content-defined chunk only
+//depend on where the chunk starts, a region's boundaries are the same as
+//those of sequential chunking from the first boundary they share. Regions
+//are resynchronized at that boundary such that the chunks are identical
+//to those of sequential chunking, and they are returned in order
+type ParallelChunker struct {
+	ra          io.ReaderAt
+	size        int64
+	regionSize  int64
+	concurrency int
+	create      ChunkerFunc
+
+	next    int64 //start of the next region that is to be chunked
+	regions []*region
+	cuts    []int64 //boundaries that are known to match sequential chunking
+	pos     int64   //end of the last chunk that was returned
+}
+
+//NewParallelChunker chunks the input of 'ra' from offset 'off' up to 'size'
+//with chunkers created by 'create', at most 'concurrency' regions of
+//'regionSize' bytes are chunked at the same time. The region size should
+//be much larger than the maximal chunk size
+func NewParallelChunker(ra io.ReaderAt, off, size, regionSize int64, concurrency int, create ChunkerFunc) (c *ParallelChunker, err error) {
+	if regionSize < 1 || concurrency < 1 || off > size {
+		return nil, fmt.Errorf("invalid parallel chunker, expected a positive region size and concurrency, got: %d, %d", regionSize, concurrency)
+	}
+
+	return &ParallelChunker{
+		ra:          ra,
+		size:        size,
+		regionSize:  regionSize,
+		concurrency: concurrency,
+		create:      create,
+		next:        off,
+		pos:         off,
+	}, nil
+}
+
+//chunkFrom chunks the input from 'start' and calls 'fn' with the end of
+//each chunk until it returns false or the input is exhausted
+func (c *ParallelChunker) chunkFrom(start int64, fn func(cut int64) bool) error {
+	cr, err := c.create(io.NewSectionReader(c.ra, start, c.size-start))
+	if err != nil {
+		return err
+	}
+
+	for cut := start; ; {
+		chunk, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		cut += int64(len(chunk))
+		if !fn(cut) {
+			return nil
+		}
+	}
+}
+
+//schedule starts chunking regions until enough are in flight
+func (c *ParallelChunker) schedule() {
+	for len(c.regions) < c.concurrency && c.next < c.size {
+		r := &region{start: c.next, end: c.next + c.regionSize, resCh: make(chan *regionResult, 1)}
+		if r.end > c.size {
+			r.end = c.size
+		}
+
+		go func() {
+			res := &regionResult{}
+			res.err = c.chunkFrom(r.start, func(cut int64) bool {
+				res.cuts = append(res.cuts, cut)
+				return cut < r.end
+			})
+
+			r.resCh <- res
+		}()
+
+		c.regions = append(c.regions, r)
+		c.next = r.end
+	}
+}
+
+//advance takes the result of the next region and appends the boundaries
+//that match sequential chunking
+func (c *ParallelChunker) advance() error {
+	r := c.regions[0]
+	c.regions = c.regions[1:]
+	res := <-r.resCh
+	if res.err != nil {
+		return fmt.Errorf("failed to chunk region at %d: %v", r.start, res.err)
+	}
+
+	//the sequential boundary from which this region is chunked, the last
+	//boundary of the previous region may lie beyond the start of this one
+	last := c.pos
+	if len(c.cuts) > 0 {
+		last = c.cuts[len(c.cuts)-1]
+	}
+
+	if last >= r.end {
+		return nil
+	}
+
+	//the region was chunked from a sequential boundary or shares one
+	if last == r.start {
+		c.cuts = append(c.cuts, res.cuts...)
+		return nil
+	}
+
+	if i := sort.Search(len(res.cuts), func(i int) bool { return res.cuts[i] >= last }); i < len(res.cuts) && res.cuts[i] == last {
+		c.cuts = append(c.cuts, res.cuts[i+1:]...)
+		return nil
+	}
+
+	//resynchronize by chunking sequentially until a boundary is shared or
+	//the end of the region is passed
+	shared := map[int64]int{}
+	for i, cut := range res.cuts {
+		shared[cut] = i
+	}
+
+	synced := -1
+	err := c.chunkFrom(last, func(cut int64) bool {
+		if i, ok := shared[cut]; ok {
+			synced = i
+			return false
+		}
+
+		c.cuts = append(c.cuts, cut)
+		return cut < r.end
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resynchronize region at %d: %v", r.start, err)
+	}
+
+	if synced >= 0 {
+		c.cuts = append(c.cuts, res.cuts[synced:]...)
+	}
+
+	return nil
+}
+
+//Read will return the next chunk for processing
+func (c *ParallelChunker) Read() (chunk []byte, err error) {
+	for len(c.cuts) == 0 {
+		c.schedule()
+		if len(c.regions) == 0 {
+			return nil, io.EOF
+		}
+
+		err = c.advance()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunk = make([]byte, c.cuts[0]-c.pos)
+	n, err := c.ra.ReadAt(chunk, c.pos)
+	if n < len(chunk) {
+		return nil, fmt.Errorf("failed to read chunk at %d: %v", c.pos, err)
+	}
+
+	c.pos = c.cuts[0]
+	c.cuts = c.cuts[1:]
+	return chunk, nil
+}
+
+//newParallelChunker returns a parallel chunker if 'input' supports random
+//access, e.g: a regular file, and is large enough to benefit from it
+func newParallelChunker(input io.Reader, maxSize int, create ChunkerFunc) (c *ParallelChunker, ok bool) {
+	ra, isRA := input.(io.ReaderAt)
+	seeker, isSeeker := input.(io.Seeker)
+	if !isRA || !isSeeker {
+		return nil, false
+	}
+
+	//pipes such as STDIN cannot seek
+	off, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, false
+	}
+
+	_, err = seeker.Seek(off, io.SeekStart)
+	if err != nil {
+		return nil, false
+	}
+
+	regionSize := int64(DefaultRegionSize)
+	if r := int64(maxSize) * 8; r > regionSize {
+		regionSize = r
+	}
+
+	if size-off < 2*regionSize || runtime.GOMAXPROCS(0) < 2 {
+		return nil, false
+	}
+
+	c, err = NewParallelChunker(ra, off, size, regionSize, runtime.GOMAXPROCS(0), create)
+	if err != nil {
+		return nil, false
+	}
+
+	return c, true
+}
@@ -0,0 +1,44 @@
+package bitschunks_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/chunks"
+)
+
+func TestParallelChunkingIsSequential(t *testing.T) {
+	input := randb(7, 8*1024*1024)
+	settings := bitschunks.ChunkerSettings{MinSize: 2 * 1024, AvgSize: 8 * 1024, MaxSize: 64 * 1024, BlockSize: 4096}
+	for _, ctype := range bitschunks.SupportedChunkers {
+		for _, regionSize := range []int64{100 * 1024, 1024 * 1024, 3*1024*1024 + 7} {
+			create := func(r io.Reader) (bits.ChunkReader, error) {
+				return bitschunks.CreateChunker(ctype, secret, r, settings)
+			}
+
+			seq, err := create(bytes.NewBuffer(input))
+			if err != nil {
+				t.Fatalf("failed to create chunker: %v", err)
+			}
+
+			par, err := bitschunks.NewParallelChunker(bytes.NewReader(input), 0, int64(len(input)), regionSize, 4, create)
+			if err != nil {
+				t.Fatalf("failed to create parallel chunker: %v", err)
+			}
+
+			expected := readChunks(t, seq, input, 0, settings.MaxSize)
+			actual := readChunks(t, par, input, 0, settings.MaxSize)
+			if len(expected) != len(actual) {
+				t.Fatalf("%s (region %d): expected %d chunks, got: %d", ctype, regionSize, len(expected), len(actual))
+			}
+
+			for i := range expected {
+				if expected[i] != actual[i] {
+					t.Fatalf("%s (region %d): expected chunk %d to equal the sequential chunk", ctype, regionSize, i)
+				}
+			}
+		}
+	}
+}
@@ -1,33 +1,158 @@
 package bitschunks
 
 import (
+	"fmt"
 	"io"
+	"sync"
 
 	"github.com/restic/chunker"
 )
 
-//RabinChunker is a chunker that uses a rolling rabin checksum
+const (
+	//DefaultRabinMinSize is the default minimal size of a rabin chunk
+	DefaultRabinMinSize = chunker.MinSize
+
+	//DefaultRabinAvgSize is the default size that rabin chunks have on average
+	DefaultRabinAvgSize = 1024 * 1024
+
+	//DefaultRabinMaxSize is the default maximal size of a rabin chunk
+	DefaultRabinMaxSize = chunker.MaxSize
+
+	//size of the sliding window over which the checksum is computed
+	rabinWindowSize = 64
+)
+
+//rabinTables hold the precomputed tables for sliding bytes out of the window
+//and for the reduction modulo the polynomial
+type rabinTables struct {
+	out [256]chunker.Pol
+	mod [256]chunker.Pol
+}
+
+//tables are read-only once computed, they are cached for each polynomial
+var rabinTableCache = struct {
+	sync.Mutex
+	entries map[chunker.Pol]*rabinTables
+}{entries: map[chunker.Pol]*rabinTables{}}
+
+//RabinChunker is a chunker that uses a rolling rabin checksum. With the
+//default sizes it cuts at exactly the same boundaries as restic's chunker
+//such that chunks stored by earlier versions are deduplicated
 type RabinChunker struct {
-	buf     []byte
-	chunker *chunker.Chunker
+	input    *lookahead
+	pol      chunker.Pol
+	polShift uint
+	tables   *rabinTables
+
+	min  int
+	max  int
+	mask uint64
 }
 
-//NewRabinChunker creates a chunker that uses a rolling rabin checksum
+//NewRabinChunker creates a chunker that uses a rolling rabin checksum with
+//the default chunk sizes
 func NewRabinChunker(r io.Reader, pol chunker.Pol) *RabinChunker {
+	c, _ := NewSizedRabinChunker(r, pol, DefaultRabinMinSize, DefaultRabinAvgSize, DefaultRabinMaxSize)
+	return c
+}
+
+//NewSizedRabinChunker creates a chunker that uses a rolling rabin checksum,
+//chunks are at least 'min' and at most 'max' bytes. The average size is the
+//size that chunks would have without these bounds, it must be a power of two
+func NewSizedRabinChunker(r io.Reader, pol chunker.Pol, min, avg, max int) (c *RabinChunker, err error) {
+	if min < rabinWindowSize || min >= max || avg < 2 || avg&(avg-1) != 0 {
+		return nil, fmt.Errorf("invalid chunk sizes, expected %d <= min < max and an average that is a power of two, got: %d, %d, %d", rabinWindowSize, min, avg, max)
+	}
+
 	return &RabinChunker{
-		buf:     make([]byte, chunker.MaxSize),
-		chunker: chunker.New(r, pol),
+		input: newLookahead(r, max),
+		pol:   pol,
+		min:   min,
+		max:   max,
+		mask:  uint64(avg - 1),
+	}, nil
+}
+
+//fillTables computes the tables for the polynomial or takes them from the cache
+func (c *RabinChunker) fillTables() error {
+	if c.pol == 0 {
+		return fmt.Errorf("tables for polynomial computation not initialized")
+	}
+
+	c.polShift = uint(c.pol.Deg() - 8)
+	rabinTableCache.Lock()
+	defer rabinTableCache.Unlock()
+	if t, ok := rabinTableCache.entries[c.pol]; ok {
+		c.tables = t
+		return nil
+	}
+
+	//out[b] holds the hash of b followed by zero bytes for the rest of the
+	//window, adding it slides b out of the window
+	c.tables = &rabinTables{}
+	for b := 0; b < 256; b++ {
+		h := appendByte(0, byte(b), c.pol)
+		for i := 0; i < rabinWindowSize-1; i++ {
+			h = appendByte(h, 0, c.pol)
+		}
+
+		c.tables.out[b] = h
+	}
+
+	//mod[b] reduces the 8 bits above the degree of the polynomial with one
+	//XOR operation: b(x) * x^k mod pol | b(x) * x^k
+	k := c.pol.Deg()
+	for b := 0; b < 256; b++ {
+		c.tables.mod[b] = chunker.Pol(uint64(b)<<uint(k)).Mod(c.pol) | (chunker.Pol(b) << uint(k))
+	}
+
+	rabinTableCache.entries[c.pol] = c.tables
+	return nil
+}
+
+func appendByte(hash chunker.Pol, b byte, pol chunker.Pol) chunker.Pol {
+	hash <<= 8
+	hash |= chunker.Pol(b)
+	return hash.Mod(pol)
+}
+
+//cut returns the length of the chunk at the start of 'data'. The checksum
+//starts anew for each chunk and only covers the bytes that can end up in
+//the window of the first possible cut
+func (c *RabinChunker) cut(data []byte) int {
+	var window [rabinWindowSize]byte
+	var wpos int
+	var digest uint64
+	slide := func(b byte) {
+		out := window[wpos]
+		window[wpos] = b
+		digest ^= uint64(c.tables.out[out])
+		wpos = (wpos + 1) % rabinWindowSize
+
+		index := byte(digest >> c.polShift)
+		digest <<= 8
+		digest |= uint64(b)
+		digest ^= uint64(c.tables.mod[index])
+	}
+
+	slide(1)
+	for i := c.min - rabinWindowSize; i < len(data); i++ {
+		slide(data[i])
+		if n := i + 1; n >= c.min && (digest&c.mask == 0 || n >= c.max) {
+			return n
+		}
 	}
+
+	return len(data)
 }
 
-//Next will return the next chunk for processing
+//Read will return the next chunk for processing
 func (c *RabinChunker) Read() (chunk []byte, err error) {
-	rchunk, err := c.chunker.Next(c.buf)
-	if err != nil {
-		return chunk, err
+	if c.tables == nil {
+		if err = c.fillTables(); err != nil {
+			return nil, err
+		}
 	}
 
-	chunk = make([]byte, rchunk.Length)
-	copy(chunk, rchunk.Data)
-	return chunk, nil
+	return c.input.next(c.cut)
 }
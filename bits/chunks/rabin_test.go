@@ -0,0 +1,56 @@
+package bitschunks_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits/chunks"
+
+	"github.com/restic/chunker"
+)
+
+func TestRabinMatchesRestic(t *testing.T) {
+	for _, size := range []int{0, 100, 512 * 1024, 20 * 1024 * 1024} {
+		input := randb(int64(size), size)
+		restic := chunker.New(bytes.NewReader(input), secret.Pol())
+		rabin := bitschunks.NewRabinChunker(bytes.NewReader(input), secret.Pol())
+		buf := make([]byte, chunker.MaxSize)
+		for i := 0; ; i++ {
+			expected, rerr := restic.Next(buf)
+			actual, err := rabin.Read()
+			if rerr == io.EOF && err == io.EOF {
+				break
+			} else if rerr != nil || err != nil {
+				t.Fatalf("size %d: expected chunk %d to fail the same, got: %v and %v", size, i, rerr, err)
+			}
+
+			if !bytes.Equal(expected.Data, actual) {
+				t.Fatalf("size %d: expected chunk %d to be cut at %d, got: %d", size, i, expected.Length, len(actual))
+			}
+		}
+	}
+}
+
+func TestSizedRabin(t *testing.T) {
+	input := randb(6, 4*1024*1024)
+	min, avg, max := 2*1024, 8*1024, 32*1024
+	cr, err := bitschunks.CreateChunker("rabin", secret, bytes.NewReader(input), bitschunks.ChunkerSettings{
+		MinSize: min,
+		AvgSize: avg,
+		MaxSize: max,
+	})
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+
+	sums := readChunks(t, cr, input, min, max)
+	if n := len(input) / len(sums); n < min || n > 2*avg {
+		t.Errorf("expected chunks of around %d bytes on average, got: %d", avg, n)
+	}
+
+	_, err = bitschunks.NewSizedRabinChunker(bytes.NewReader(input), secret.Pol(), min, 3000, max)
+	if err == nil {
+		t.Errorf("expected average that is not a power of two to be refused")
+	}
+}
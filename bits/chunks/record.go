@@ -0,0 +1,91 @@
+package bitschunks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/advanderveer/libchunk/bits"
+)
+
+//ChunkerMetaName is the name under which a store records the chunker that
+//was used to cut the chunks that were put into it
+const ChunkerMetaName = "chunker"
+
+//ChunkerConfig describes a chunker and its settings. Changing them between
+//versions of a file prevents deduplication, the config is therefore recorded
+//with the data in stores that support it
+type ChunkerConfig struct {
+	Chunker string `json:"chunker,omitempty"`
+	ChunkerSettings
+}
+
+//Normalize returns the config with the default chunker and settings filled
+//in, normalized configs can be compared
+func (cc ChunkerConfig) Normalize() ChunkerConfig {
+	if cc.Chunker == "" {
+		cc.Chunker = DefaultChunker
+	}
+
+	cc.ChunkerSettings = cc.ChunkerSettings.WithDefaults(cc.Chunker)
+	return cc
+}
+
+func (cc ChunkerConfig) String() string {
+	if cc.BlockSize > 0 {
+		return fmt.Sprintf("%s (block size %d)", cc.Chunker, cc.BlockSize)
+	}
+
+	return fmt.Sprintf("%s (min %d, avg %d, max %d)", cc.Chunker, cc.MinSize, cc.AvgSize, cc.MaxSize)
+}
+
+//ReadChunkerConfig returns the chunker config that store 's' recorded, ok is
+//false if the store cannot record it or didn't record one yet
+func ReadChunkerConfig(s bits.Store) (cc ChunkerConfig, ok bool, err error) {
+	ms, isMeta := s.(bits.MetaStore)
+	if !isMeta {
+		return cc, false, nil
+	}
+
+	data, err := ms.GetMeta(ChunkerMetaName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cc, false, nil
+		}
+
+		return cc, false, fmt.Errorf("failed to get chunker of store: %v", err)
+	}
+
+	err = json.Unmarshal(data, &cc)
+	if err != nil {
+		return cc, false, fmt.Errorf("failed to decode chunker of store: %v", err)
+	}
+
+	return cc.Normalize(), true, nil
+}
+
+//RecordChunkerConfig records the (normalized) chunker config in store 's',
+//nothing is recorded if the store cannot record it or recorded one before
+func RecordChunkerConfig(s bits.Store, cc ChunkerConfig) error {
+	ms, isMeta := s.(bits.MetaStore)
+	if !isMeta {
+		return nil
+	}
+
+	_, ok, err := ReadChunkerConfig(s)
+	if err != nil || ok {
+		return err
+	}
+
+	data, err := json.Marshal(cc.Normalize())
+	if err != nil {
+		return fmt.Errorf("failed to encode chunker: %v", err)
+	}
+
+	err = ms.PutMeta(ChunkerMetaName, data)
+	if err != nil {
+		return fmt.Errorf("failed to record chunker of store: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package bitschunks_test
+
+import (
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits/chunks"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestRecordChunkerConfig(t *testing.T) {
+	store := bitsstore.NewMemStore()
+	_, ok, err := bitschunks.ReadChunkerConfig(store)
+	if err != nil || ok {
+		t.Fatalf("expected nothing to be recorded, got: %v (%v)", ok, err)
+	}
+
+	cc := bitschunks.ChunkerConfig{Chunker: "fastcdc", ChunkerSettings: bitschunks.ChunkerSettings{AvgSize: 2 * 1024 * 1024, BlockSize: 4096}}
+	err = bitschunks.RecordChunkerConfig(store, cc)
+	if err != nil {
+		t.Fatalf("failed to record chunker: %v", err)
+	}
+
+	err = bitschunks.RecordChunkerConfig(store, bitschunks.ChunkerConfig{})
+	if err != nil {
+		t.Fatalf("failed to record chunker again: %v", err)
+	}
+
+	recorded, ok, err := bitschunks.ReadChunkerConfig(store)
+	if err != nil || !ok {
+		t.Fatalf("expected chunker to be recorded, got: %v (%v)", ok, err)
+	}
+
+	expected := bitschunks.ChunkerConfig{Chunker: "fastcdc", ChunkerSettings: bitschunks.ChunkerSettings{
+		MinSize: bitschunks.DefaultFastCDCMinSize,
+		AvgSize: 2 * 1024 * 1024,
+		MaxSize: bitschunks.DefaultFastCDCMaxSize,
+	}}
+
+	if recorded != expected {
+		t.Errorf("expected first chunker to be recorded as %s, got: %s", expected, recorded)
+	}
+}
@@ -0,0 +1,103 @@
+package bits
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+//Compression identifies how a chunk is compressed before it is encrypted
+type Compression byte
+
+const (
+	//NoCompression stores chunks as is
+	NoCompression = Compression(0x00)
+
+	//FlateCompression uses DEFLATE with the default compression level
+	FlateCompression = Compression(0x01)
+
+	//FastFlateCompression uses DEFLATE at its best speed, trading the
+	//compression ratio for throughput
+	FastFlateCompression = Compression(0x02)
+)
+
+//SupportedCompressions holds identifiers for all supported compressions
+var SupportedCompressions = []string{"none", "flate", "flate-fast"}
+
+//ParseCompression returns the compression for one of the identifiers in
+//SupportedCompressions
+func ParseCompression(name string) (c Compression, err error) {
+	for i, supported := range SupportedCompressions {
+		if supported == name {
+			return Compression(i), nil
+		}
+	}
+
+	return c, fmt.Errorf("compression '%s' is not supported, available compressions are: %v", name, SupportedCompressions)
+}
+
+//String returns the identifier of the compression
+func (c Compression) String() string {
+	if int(c) < len(SupportedCompressions) {
+		return SupportedCompressions[c]
+	}
+
+	return fmt.Sprintf("unknown(%d)", c)
+}
+
+var flateWriters = map[Compression]*sync.Pool{
+	FlateCompression:     {New: func() interface{} { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }},
+	FastFlateCompression: {New: func() interface{} { w, _ := flate.NewWriter(nil, flate.BestSpeed); return w }},
+}
+
+//compressChunk compresses a chunk, if compression doesn't reduce the size of
+//the chunk it is returned as is with NoCompression
+func compressChunk(c Compression, chunk []byte) (compressed []byte, used Compression, err error) {
+	pool, ok := flateWriters[c]
+	if !ok {
+		if c == NoCompression {
+			return chunk, NoCompression, nil
+		}
+
+		return nil, c, fmt.Errorf("unsupported compression '%s'", c)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(chunk)))
+	w := pool.Get().(*flate.Writer)
+	defer pool.Put(w)
+	w.Reset(buf)
+	if _, err = w.Write(chunk); err != nil {
+		return nil, c, fmt.Errorf("failed to compress chunk: %v", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, c, fmt.Errorf("failed to compress chunk: %v", err)
+	}
+
+	if buf.Len() >= len(chunk) {
+		return chunk, NoCompression, nil
+	}
+
+	return buf.Bytes(), c, nil
+}
+
+//decompressChunk reverses the compression of a chunk
+func decompressChunk(c Compression, compressed []byte) (chunk []byte, err error) {
+	switch c {
+	case NoCompression:
+		return compressed, nil
+	case FlateCompression, FastFlateCompression:
+		r := flate.NewReader(bytes.NewReader(compressed))
+		defer r.Close()
+		chunk, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %v", err)
+		}
+
+		return chunk, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression '%s'", c)
+	}
+}
@@ -0,0 +1,63 @@
+package bits_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestCompression(t *testing.T) {
+	compressible := []byte(strings.Repeat(`{"level": "info", "msg": "request handled", "status": 200}`+"\n", 200000))
+	random := randb(9 * 1024 * 1024)
+	for _, name := range bits.SupportedCompressions {
+		c, err := bits.ParseCompression(name)
+		if err != nil {
+			t.Fatalf("failed to parse supported compression: %v", err)
+		}
+
+		for dname, data := range map[string][]byte{"compressible": compressible, "random": random} {
+			t.Run(name+"_"+dname, func(t *testing.T) {
+				store := bitsstore.NewMemStore()
+				conf := withStore(t, defaultConf(t, secret), store)
+				conf.Compression = c
+
+				keys := bitskeys.NewMemIterator()
+				err := bits.Put(randBytesInput(bytes.NewReader(data), secret), keys, conf)
+				if err != nil {
+					t.Fatalf("failed to put: %v", err)
+				}
+
+				stored := 0
+				for _, chunk := range store.Chunks {
+					stored += len(chunk)
+				}
+
+				if c != bits.NoCompression && dname == "compressible" && stored > len(data)/10 {
+					t.Errorf("expected compressible data to be stored compressed, stored %d bytes for %d input bytes", stored, len(data))
+				}
+
+				if dname == "random" && stored > len(data)+len(keys.Keys)*(bits.EnvelopeHeaderSize+conf.AEAD.NonceSize()+conf.AEAD.Overhead()) {
+					t.Errorf("expected incompressible chunks to be stored uncompressed, stored %d bytes for %d input bytes", stored, len(data))
+				}
+
+				output := bytes.NewBuffer(nil)
+				err = bits.Get(keys, output, conf)
+				if err != nil {
+					t.Fatalf("failed to get: %v", err)
+				}
+
+				if !bytes.Equal(output.Bytes(), data) {
+					t.Error("expected output to equal input")
+				}
+			})
+		}
+	}
+
+	if _, err := bits.ParseCompression("foo"); err == nil {
+		t.Error("expected unsupported compression to fail")
+	}
+}
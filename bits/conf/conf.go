@@ -5,14 +5,80 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io"
 
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/chunks"
 	"github.com/advanderveer/libchunk/bits/store"
 )
 
-//StoreConfig holds the configuration for a named store
+//StoreConfig holds the configuration for a named store, settings of kinds
+//that are not known to this package are kept such that they can be decoded
+//by the factory that is registered for the kind
 type StoreConfig struct {
+	raw json.RawMessage
+
 	Kind string `json:"kind"`
+	bitsstore.BoltStoreConfig
+	bitsstore.DirStoreConfig
 	bitsstore.S3StoreConfig
+	bitsstore.PackStoreConfig
+}
+
+//UnmarshalJSON decodes the known settings and keeps the raw data
+func (sconf *StoreConfig) UnmarshalJSON(data []byte) error {
+	type Alias StoreConfig
+	if err := json.Unmarshal(data, (*Alias)(sconf)); err != nil {
+		return err
+	}
+
+	sconf.raw = append(json.RawMessage{}, data...)
+	return nil
+}
+
+//MarshalJSON encodes the known settings on top of the raw settings it
+//was decoded from, such that settings of unknown kinds are not lost
+func (sconf *StoreConfig) MarshalJSON() (b []byte, err error) {
+	type Alias StoreConfig
+	known, err := json.Marshal((*Alias)(sconf))
+	if err != nil || sconf.raw == nil {
+		return known, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(sconf.raw, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode raw store settings: %w", err)
+	}
+
+	err = json.Unmarshal(known, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge store settings: %w", err)
+	}
+
+	return json.Marshal(fields)
+}
+
+//storeSettings exposes a store configuration to a store factory
+type storeSettings struct {
+	sconf   *StoreConfig
+	secrets map[string]string
+}
+
+//Decode the raw settings and then the known settings into 'v'
+func (s *storeSettings) Decode(v interface{}) error {
+	data, err := s.sconf.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+//Secret returns a decrypted secret by name
+func (s *storeSettings) Secret(name string) (secret string, ok bool) {
+	secret, ok = s.secrets[name]
+	return secret, ok
 }
 
 //Config is the structure that is (de)serialized in order
@@ -24,6 +90,49 @@ type Config struct {
 
 	Stores  map[string]*StoreConfig `json:"stores"`
 	Secrets map[string]string       `json:"secrets"`
+
+	//how input is cut into chunks, command line options take precedence
+	Chunker *bitschunks.ChunkerConfig `json:"chunker,omitempty"`
+}
+
+//NewConfig sets up an empty configuration that uses 'aead' to encrypt and
+//decrypt its secrets
+func NewConfig(aead cipher.AEAD) *Config {
+	return &Config{
+		aead:    aead,
+		Stores:  map[string]*StoreConfig{},
+		Secrets: map[string]string{},
+	}
+}
+
+//ReadConfig decodes a configuration from reader 'r', encrypted secrets are
+//decrypted using 'aead'
+func ReadConfig(r io.Reader, aead cipher.AEAD) (conf *Config, err error) {
+	conf = NewConfig(aead)
+	dec := json.NewDecoder(r)
+	err = dec.Decode(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config: %v", err)
+	}
+
+	return conf, nil
+}
+
+//CreateStore sets up the store that is configured under 'name' using the
+//factory that is registered for its kind. Secrets required by the store
+//are taken from the (decrypted) secrets map
+func (conf *Config) CreateStore(name string) (s bits.Store, err error) {
+	sconf, ok := conf.Stores[name]
+	if !ok || sconf == nil {
+		return nil, fmt.Errorf("no store named '%s' is configured", name)
+	}
+
+	s, err = bitsstore.CreateStore(sconf.Kind, &storeSettings{sconf, conf.Secrets})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store '%s': %w", name, err)
+	}
+
+	return s, nil
 }
 
 //UnmarshalJSON decode the config structure and decrypt
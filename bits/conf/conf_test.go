@@ -5,6 +5,10 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/advanderveer/libchunk/bits"
@@ -122,3 +126,103 @@ func TestS3StoreCreation(t *testing.T) {
 	}
 
 }
+
+func TestReadConfigCreateStore(t *testing.T) {
+	secret, err := bits.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := bits.DefaultConf(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbdir, err := ioutil.TempDir("", "bits_conf_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dbdir)
+	conf1 := NewConfig(conf.AEAD)
+	conf1.Stores["local"] = &StoreConfig{Kind: "bolt", BoltStoreConfig: bitsstore.BoltStoreConfig{Path: filepath.Join(dbdir, "db.bolt")}}
+	conf1.Stores["remote"] = &StoreConfig{Kind: "s3", S3StoreConfig: bitsstore.S3StoreConfig{Host: "localhost", AccessKey: "my-access-key"}}
+	conf1.Stores["cache"] = &StoreConfig{Kind: "mem"}
+	conf1.Stores["other"] = &StoreConfig{Kind: "foo"}
+	conf1.Secrets[bitsstore.DefaultS3SecretKeyName] = "my-secret"
+
+	data, err := json.Marshal(conf1)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	conf2, err := ReadConfig(bytes.NewReader(data), conf.AEAD)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+
+	for _, name := range []string{"local", "remote", "cache"} {
+		if _, err = conf2.CreateStore(name); err != nil {
+			t.Errorf("failed to create store '%s': %v", name, err)
+		}
+	}
+
+	if _, err = conf2.CreateStore("other"); err == nil {
+		t.Error("expected store of unsupported kind to fail")
+	}
+
+	if _, err = conf2.CreateStore("bogus"); err == nil {
+		t.Error("expected unconfigured store to fail")
+	}
+
+	delete(conf2.Secrets, bitsstore.DefaultS3SecretKeyName)
+	_, err = conf2.CreateStore("remote")
+	if verr := (*bitsstore.ValidationError)(nil); !errors.As(err, &verr) || verr.Field != "s3_secret_key_name" {
+		t.Errorf("expected validation error for s3 store without its secret, got: %v", err)
+	}
+}
+
+//decoded holds the bucket setting that the test kind decoded last
+var decoded string
+
+//the test kind is registered once, registering it again panics
+func init() {
+	bitsstore.RegisterStore("conf-test-kind", func(settings bitsstore.StoreSettings) (bits.Store, error) {
+		v := struct {
+			Bucket string `json:"test_bucket"`
+		}{}
+
+		if err := settings.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		decoded = v.Bucket
+		return bitsstore.NewMemStore(), nil
+	})
+}
+
+func TestThirdPartyStoreSettings(t *testing.T) {
+	decoded = ""
+	conf1, err := ReadConfig(bytes.NewBufferString(`{"stores": {"foo": {"kind": "conf-test-kind", "test_bucket": "my-bucket"}}}`), nil)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+
+	_, err = conf1.CreateStore("foo")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	if decoded != "my-bucket" {
+		t.Errorf("expected third party setting to be decoded, got: '%s'", decoded)
+	}
+
+	data, err := json.Marshal(conf1.Stores["foo"])
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	if !bytes.Contains(data, []byte(`"test_bucket":"my-bucket"`)) {
+		t.Errorf("expected third party settings to survive encoding, got: %s", data)
+	}
+}
@@ -3,22 +3,67 @@ package bits
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/sha256"
 	"fmt"
+	"time"
 )
 
 //Config describes how the library's Split, Join and Push behaves
 type Config struct {
-	AEAD    cipher.AEAD
-	KeyHash KeyHash
+	AEAD        cipher.AEAD
+	Cipher      CipherID
+	KeyHash     KeyHash
+	KeyHashMode KeyHashMode
+	Compression Compression
+
+	//derives nonces from the plaintext instead of generating random ones
+	//such that identical chunks are stored as identical bytes
+	NonceHash NonceHash
 
 	PutConcurrency  int
 	MoveConcurrency int
 	GetConcurrency  int
+	GCConcurrency   int
+
+	//number of plaintext chunks a Reader keeps in memory
+	ReadCacheSize int
 
 	Stores StoreMap
 
-	Index KeyIndex
+	//names of the stores that chunks are gotten from, in the order they are
+	//asked. All stores are asked in order of their latency if empty
+	GetOrder []string
+
+	//ask the next store for a chunk if the previous store hasn't answered
+	//within this duration, e.g: if a remote is sometimes slow. Stores are
+	//only asked after the previous store failed if zero
+	HedgeDelay time.Duration
+
+	//index of the keys that a remote holds, Move fills an index in memory
+	//if none is configured unless NoIndex is set, e.g: if listing the
+	//remote costs more than moving
+	Index   KeyIndex
+	NoIndex bool
+
+	//unreachable chunks that were put less than this long ago are kept by
+	//garbage collection, e.g: chunks of a put whose keys aren't written yet
+	GCGracePeriod time.Duration
+
+	//garbage collection only reports which chunks it would delete
+	GCDryRun bool
+
+	//keys that were moved earlier, e.g: by an interrupted move. It
+	//is written to concurrently
+	Journal KeyIndex
+}
+
+//cipher returns the configured cipher, a configuration that doesn't specify
+//one uses AES-256 in Galois/Counter Mode
+func (conf Config) cipher() CipherID {
+	if conf.Cipher == 0 {
+		return CipherAES256GCM
+	}
+
+	return conf.Cipher
 }
 
 //DefaultConf sets up sensible configs
@@ -33,14 +78,21 @@ func DefaultConf(secret Secret) (conf Config, err error) {
 		return conf, fmt.Errorf("failed to setup GCM cipher mode: %v", err)
 	}
 
+	kh, err := NewKeyHash(KeyedKeyHash, secret)
+	if err != nil {
+		return conf, err
+	}
+
 	return Config{
 		PutConcurrency:  64,
 		MoveConcurrency: 64,
 		GetConcurrency:  10,
+		GCConcurrency:   64,
+		ReadCacheSize:   8,
 		AEAD:            aead,
+		Cipher:          CipherAES256GCM,
 		Stores:          StoreMap{},
-		KeyHash: func(b []byte) K {
-			return sha256.Sum256(b)
-		},
+		KeyHash:         kh,
+		KeyHashMode:     KeyedKeyHash,
 	}, nil
 }
@@ -0,0 +1,143 @@
+package bits_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+//blockingStore blocks each operation until the context is done
+type blockingStore struct{}
+
+func (store *blockingStore) Put(k bits.K, c []byte) error {
+	return store.PutContext(context.Background(), k, c)
+}
+
+func (store *blockingStore) Get(k bits.K) (c []byte, err error) {
+	return store.GetContext(context.Background(), k)
+}
+
+func (store *blockingStore) PutContext(ctx context.Context, k bits.K, c []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (store *blockingStore) GetContext(ctx context.Context, k bits.K) (c []byte, err error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+//waitForGoroutines waits for the number of goroutines to drop to 'n'
+func waitForGoroutines(t *testing.T, n int) {
+	for i := 0; i < 100; i++ {
+		if runtime.NumGoroutine() <= n {
+			return
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Errorf("expected goroutines to return to %d, got: %d", n, runtime.NumGoroutine())
+}
+
+func TestContextDeadlines(t *testing.T) {
+	data := randb(9 * 1024 * 1024)
+	keys := bitskeys.NewMemIterator()
+	store := bitsstore.NewMemStore()
+	err := bits.Put(randBytesInput(bytes.NewReader(data), secret), keys, withStore(t, defaultConf(t, secret), store))
+	if err != nil {
+		t.Fatalf("couldnt split for test prep: %v", err)
+	}
+
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Minute):
+		}
+	}))
+
+	defer hanging.Close()
+	hangingRemote := bitsstore.NewS3Remote("http", strings.TrimPrefix(hanging.URL, "http://"), "", "", "")
+
+	//http connections are kept alive by the transport so goroutines
+	//are only compared when no remote is involved
+	cases := []struct {
+		name      string
+		countLeak bool
+		run       func(ctx context.Context) error
+	}{{
+		"put_to_blocking_store",
+		true,
+		func(ctx context.Context) error {
+			conf := withStore(t, defaultConf(t, secret), &blockingStore{})
+			return bits.PutContext(ctx, randBytesInput(bytes.NewReader(data), secret), bitskeys.NewMemIterator(), conf)
+		},
+	}, {
+		"get_from_blocking_store",
+		true,
+		func(ctx context.Context) error {
+			conf := withStore(t, defaultConf(t, secret), &blockingStore{})
+			return bits.GetContext(ctx, bitskeys.NewPopulatedMemIterator(keys.Keys), bytes.NewBuffer(nil), conf)
+		},
+	}, {
+		"get_from_hanging_remote",
+		false,
+		func(ctx context.Context) error {
+			conf := withRemote(t, defaultConf(t, secret), hangingRemote)
+			return bits.GetContext(ctx, bitskeys.NewPopulatedMemIterator(keys.Keys), bytes.NewBuffer(nil), conf)
+		},
+	}, {
+		"move_to_hanging_remote",
+		false,
+		func(ctx context.Context) error {
+			conf := withRemote(t, withStore(t, defaultConf(t, secret), store), hangingRemote)
+			_, err := bits.MoveContext(ctx, bitskeys.NewPopulatedMemIterator(keys.Keys), bitskeys.NewMemIterator(), conf)
+			return err
+		},
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n := runtime.NumGoroutine()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+			defer cancel()
+
+			start := time.Now()
+			err := c.run(ctx)
+			if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+				t.Errorf("expected deadline error, got: %v", err)
+			}
+
+			if time.Since(start) > time.Second*5 {
+				t.Errorf("expected operation to be aborted quickly, took: %s", time.Since(start))
+			}
+
+			if c.countLeak {
+				waitForGoroutines(t, n)
+			}
+		})
+	}
+}
+
+func TestContextCancelledBeforeStart(t *testing.T) {
+	conf := withStore(t, defaultConf(t, secret), bitsstore.NewMemStore())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := bits.PutContext(ctx, randBytesInput(bytes.NewReader(randb(1024*1024)), secret), bitskeys.NewMemIterator(), conf)
+	if err != context.Canceled {
+		t.Errorf("expected cancelled error, got: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package bits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+//CipherID identifies the cipher that was used to encrypt a chunk
+type CipherID byte
+
+const (
+	//CipherAES256GCM identifies AES-256 in Galois/Counter Mode
+	CipherAES256GCM = CipherID(0x01)
+)
+
+const (
+	//EnvelopeVersion is the version of the envelope format that is written
+	EnvelopeVersion = 0x01
+
+	//EnvelopeHeaderSize is the size of the header that precedes the nonce
+	EnvelopeHeaderSize = 7
+
+	//envelope flags that identify the compression
+	flagCompressionMask = 0x0F
+
+	//envelope flag that is set if the nonce was derived from the plaintext
+	flagDeterministic = 0x10
+)
+
+//EnvelopeMagic are the first bytes of each chunk that is stored in an
+//envelope, chunks that don't start with it are stored in the legacy format
+var EnvelopeMagic = []byte("BITS")
+
+//ErrNoEnvelope is returned when parsing a chunk that doesn't start with the
+//magic bytes of an envelope, e.g: because it is stored in the legacy format
+var ErrNoEnvelope = errors.New("chunk doesn't start with an envelope header")
+
+//cipherNonceSizes holds the nonce size of each known cipher
+var cipherNonceSizes = map[CipherID]int{
+	CipherAES256GCM: 12,
+}
+
+//Envelope describes how a chunk is stored: a header with the magic bytes, a
+//format version, the cipher and flags that is followed by the nonce and the
+//ciphertext. The header is authenticated as additional data of the cipher
+type Envelope struct {
+	Version    byte
+	Cipher     CipherID
+	Flags      byte
+	Header     []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+//Compression returns the compression of the plaintext as flagged
+func (env *Envelope) Compression() Compression {
+	return Compression(env.Flags & flagCompressionMask)
+}
+
+//Deterministic returns whether the nonce was derived from the plaintext, if
+//so the same chunk is always stored as the same bytes
+func (env *Envelope) Deterministic() bool {
+	return env.Flags&flagDeterministic != 0
+}
+
+//ParseEnvelope parses the envelope of a stored chunk without decrypting it,
+//it returns ErrNoEnvelope if the chunk is not stored in an envelope or an
+//error if the envelope has an unknown version, cipher or flags
+func ParseEnvelope(sealed []byte) (env *Envelope, err error) {
+	if !bytes.HasPrefix(sealed, EnvelopeMagic) {
+		return nil, ErrNoEnvelope
+	}
+
+	if len(sealed) < EnvelopeHeaderSize {
+		return nil, fmt.Errorf("envelope header is too small (must be %d long)", EnvelopeHeaderSize)
+	}
+
+	env = &Envelope{
+		Version: sealed[4],
+		Cipher:  CipherID(sealed[5]),
+		Flags:   sealed[6],
+		Header:  sealed[:EnvelopeHeaderSize],
+	}
+
+	if env.Version != EnvelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+
+	ns, ok := cipherNonceSizes[env.Cipher]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher %d", env.Cipher)
+	}
+
+	if int(env.Compression()) >= len(SupportedCompressions) || env.Flags&^(flagCompressionMask|flagDeterministic) != 0 {
+		return nil, fmt.Errorf("unsupported envelope flags %08b", env.Flags)
+	}
+
+	if len(sealed) < EnvelopeHeaderSize+ns {
+		return nil, fmt.Errorf("envelope is too small (must be at least %d long)", EnvelopeHeaderSize+ns)
+	}
+
+	env.Nonce = sealed[EnvelopeHeaderSize : EnvelopeHeaderSize+ns]
+	env.Ciphertext = sealed[EnvelopeHeaderSize+ns:]
+	return env, nil
+}
+
+//newEnvelopeHeader creates the header for a chunk that is encrypted with
+//'cipher' and compressed with 'c', optionally with a deterministic nonce
+func newEnvelopeHeader(cipher CipherID, c Compression, deterministic bool) []byte {
+	flags := byte(c) & flagCompressionMask
+	if deterministic {
+		flags |= flagDeterministic
+	}
+
+	return append(append([]byte{}, EnvelopeMagic...), EnvelopeVersion, byte(cipher), flags)
+}
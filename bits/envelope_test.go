@@ -0,0 +1,157 @@
+package bits_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestEnvelope(t *testing.T) {
+	store := bitsstore.NewMemStore()
+	conf := withStore(t, defaultConf(t, secret), store)
+	conf.Compression = bits.FlateCompression
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewReader([]byte(strings.Repeat("foo", 1000))), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	sealed := store.Chunks[keys.Keys[0]]
+	env, err := bits.ParseEnvelope(sealed)
+	if err != nil {
+		t.Fatalf("expected stored chunk to have an envelope: %v", err)
+	}
+
+	if env.Version != bits.EnvelopeVersion || env.Cipher != bits.CipherAES256GCM || env.Compression() != bits.FlateCompression {
+		t.Errorf("unexpected envelope header: %+v", env)
+	}
+
+	if len(env.Nonce) != conf.AEAD.NonceSize() {
+		t.Errorf("expected nonce of %d bytes, got: %d", conf.AEAD.NonceSize(), len(env.Nonce))
+	}
+
+	for i, c := range []struct {
+		name        string
+		header      []byte
+		expectedErr string
+	}{
+		{"unknown_version", []byte{'B', 'I', 'T', 'S', 0xFF, 0x01, 0x00}, "unsupported envelope version 255"},
+		{"unknown_cipher", []byte{'B', 'I', 'T', 'S', 0x01, 0xFF, 0x00}, "unsupported cipher 255"},
+		{"unknown_flags", []byte{'B', 'I', 'T', 'S', 0x01, 0x01, 0xFF}, "unsupported envelope flags"},
+	} {
+		tampered := append(append([]byte{}, c.header...), sealed[bits.EnvelopeHeaderSize:]...)
+		if _, err = bits.ParseEnvelope(tampered); err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+			t.Errorf("%s: expected parsing to fail with '%s', got: %v", c.name, c.expectedErr, err)
+		}
+
+		store.Chunks[bits.K{byte(i)}] = tampered
+		err = bits.Get(bitskeys.NewPopulatedMemIterator([]bits.K{{byte(i)}}), bytes.NewBuffer(nil), conf)
+		if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+			t.Errorf("%s: expected get to fail with '%s', got: %v", c.name, c.expectedErr, err)
+		}
+	}
+
+	if _, err = bits.ParseEnvelope([]byte("foo")); err != bits.ErrNoEnvelope {
+		t.Errorf("expected chunk without magic to have no envelope, got: %v", err)
+	}
+
+	//changing the flags in the header must fail authentication
+	tampered := append([]byte{}, sealed...)
+	tampered[6] = byte(bits.FastFlateCompression)
+	store.Chunks[keys.Keys[0]] = tampered
+	err = bits.Get(keys, bytes.NewBuffer(nil), conf)
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Errorf("expected tampered header to fail authentication, got: %v", err)
+	}
+
+	//a configuration without a cipher uses the default cipher
+	conf.Cipher = 0
+	keys = bitskeys.NewMemIterator()
+	err = bits.Put(randBytesInput(bytes.NewReader(randb(1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("expected put without a configured cipher to succeed, got: %v", err)
+	}
+
+	keys.Reset()
+	if err = bits.Get(keys, bytes.NewBuffer(nil), conf); err != nil {
+		t.Errorf("expected get without a configured cipher to succeed, got: %v", err)
+	}
+}
+
+func TestReadLegacyChunks(t *testing.T) {
+	store := bitsstore.NewMemStore()
+	conf := withStore(t, defaultConf(t, secret), store)
+	plaintext := []byte("hello world")
+
+	//a legacy chunk is just the nonce followed by the ciphertext, its
+	//nonce may start with the envelope magic by chance
+	nonce := make([]byte, conf.AEAD.NonceSize())
+	store.Chunks[bits.K{0x01}] = conf.AEAD.Seal(nonce, nonce, plaintext, nil)
+	nonce = append(append([]byte{}, bits.EnvelopeMagic...), 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00)
+	store.Chunks[bits.K{0x02}] = conf.AEAD.Seal(nonce, nonce, plaintext, nil)
+
+	for _, k := range []bits.K{{0x01}, {0x02}} {
+		output := bytes.NewBuffer(nil)
+		err := bits.Get(bitskeys.NewPopulatedMemIterator([]bits.K{k}), output, conf)
+		if err != nil {
+			t.Fatalf("failed to get legacy chunk '%s': %v", k, err)
+		}
+
+		if !bytes.Equal(output.Bytes(), plaintext) {
+			t.Errorf("expected legacy chunk '%s' to equal plaintext", k)
+		}
+	}
+}
+
+func TestDeterministicEncryption(t *testing.T) {
+	input := randb(1024 * 1024)
+	stored := []map[bits.K][]byte{}
+	for _, nh := range []bits.NonceHash{nil, bits.NewNonceHash(secret), bits.NewNonceHash(secret)} {
+		store := bitsstore.NewMemStore()
+		conf := withStore(t, defaultConf(t, secret), store)
+		conf.NonceHash = nh
+		keys := bitskeys.NewMemIterator()
+		err := bits.Put(randBytesInput(bytes.NewReader(input), secret), keys, conf)
+		if err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+
+		output := bytes.NewBuffer(nil)
+		err = bits.Get(keys, output, conf)
+		if err != nil {
+			t.Fatalf("failed to get: %v", err)
+		}
+
+		if !bytes.Equal(output.Bytes(), input) {
+			t.Fatalf("expected output to equal input")
+		}
+
+		for _, k := range keys.Keys {
+			env, err := bits.ParseEnvelope(store.Chunks[k])
+			if err != nil || env.Deterministic() != (nh != nil) {
+				t.Errorf("expected envelope to flag deterministic as %v, got: %v (%v)", nh != nil, env, err)
+			}
+		}
+
+		stored = append(stored, store.Chunks)
+	}
+
+	for k, sealed := range stored[1] {
+		if !bytes.Equal(sealed, stored[2][k]) {
+			t.Errorf("expected deterministic chunk '%s' to be stored as identical bytes", k)
+		}
+
+		if bytes.Equal(sealed, stored[0][k]) {
+			t.Errorf("expected random nonce chunk '%s' to differ from the deterministic one", k)
+		}
+	}
+
+	other := bits.NewNonceHash(secret)
+	if bytes.Equal(other([]byte("a"), []byte("foo")), other([]byte("b"), []byte("foo"))) {
+		t.Errorf("expected nonce to depend on the header")
+	}
+}
@@ -0,0 +1,201 @@
+package bits
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//GCEpochMetaName is the name under which a MetaStore records when garbage
+//was last collected from it, indexes of the store that were synced before
+//may hold keys of chunks that were deleted
+const GCEpochMetaName = "gc_epoch"
+
+//GCStats describes what a garbage collection found and did
+type GCStats struct {
+	Live      int64 //number of distinct keys that were read from the live key lists
+	Kept      int64 //number of chunks kept because they are live
+	Young     int64 //number of unreachable chunks kept because they were put within the grace period
+	Deleted   int64 //number of unreachable chunks that were deleted, or would be in a dry run
+	Reclaimed int64 //number of bytes that the deleted chunks took
+}
+
+//GC marks all keys read from key reader 'kr' as live and deletes every other
+//chunk from the store that collects garbage, unless it was put within the
+//configured grace period. All keys are read before anything is deleted, if
+//reading fails nothing is deleted. The store must support listing and
+//deleting its chunks. Once chunks were deleted a new epoch is recorded in
+//the store's meta, if it has any, such that indexes of the store go stale
+func GC(kr KeyReader, conf Config) (stats GCStats, err error) {
+	return GCContext(context.Background(), kr, conf)
+}
+
+//GCContext is like GC but stops reading keys and deleting chunks when the
+//context is cancelled or its deadline exceeds. It only returns when all
+//concurrent deletes have stopped. Stats are returned even when collection
+//failed, such that the chunks that were deleted can be reported
+func GCContext(ctx context.Context, kr KeyReader, conf Config) (stats GCStats, err error) {
+	started := time.Now()
+	store, err := conf.Stores.GCStore()
+	if err != nil {
+		return stats, fmt.Errorf("couldnt get store to collect garbage from: %v", err)
+	}
+
+	lstore, ok := store.(ListStore)
+	if !ok {
+		return stats, fmt.Errorf("store of type %T cannot list its chunks", store)
+	}
+
+	dstore, ok := store.(DeleteStore)
+	if !ok && !conf.GCDryRun {
+		return stats, fmt.Errorf("store of type %T cannot delete chunks", store)
+	}
+
+	//mark
+	live := map[K]struct{}{}
+	for ctx.Err() == nil {
+		k, err := kr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, fmt.Errorf("failed to iterate into next key: %v", err)
+		}
+
+		live[k] = struct{}{}
+	}
+
+	if err = ctx.Err(); err != nil {
+		return stats, err
+	}
+
+	stats.Live = int64(len(live))
+
+	//chunks that were put after collection started are young too, unless
+	//there is no grace period at all
+	garbage := []ChunkInfo{}
+	err = listContext(ctx, lstore, func(info ChunkInfo) error {
+		if _, ok := live[info.K]; ok {
+			stats.Kept++
+			return nil
+		}
+
+		if conf.GCGracePeriod > 0 && !info.ModTime.IsZero() && started.Sub(info.ModTime) < conf.GCGracePeriod {
+			stats.Young++
+			return nil
+		}
+
+		garbage = append(garbage, info)
+		return nil
+	})
+
+	if err != nil {
+		return stats, fmt.Errorf("failed to list chunks: %v", err)
+	}
+
+	if conf.GCDryRun {
+		for _, info := range garbage {
+			stats.Deleted++
+			stats.Reclaimed += info.Size
+		}
+
+		return stats, nil
+	}
+
+	//sweep, the first failure cancels the other deletes
+	wg := &workGroup{}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n := conf.GCConcurrency
+	if n < 1 {
+		n = 1
+	}
+
+	mu := sync.Mutex{}
+	sem := make(chan struct{}, n)
+	for _, info := range garbage {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		info := info
+		wg.Go(func() {
+			defer func() { <-sem }()
+			derr := deleteContext(ctx, dstore, info.K)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if derr != nil {
+				if err == nil {
+					err = fmt.Errorf("failed to delete chunk '%s': %v", info.K, derr)
+				}
+
+				cancel()
+				return
+			}
+
+			stats.Deleted++
+			stats.Reclaimed += info.Size
+		})
+	}
+
+	wg.StopAndWait()
+	if stats.Deleted > 0 {
+		if eerr := recordGCEpoch(store); eerr != nil && err == nil {
+			err = eerr
+		}
+	}
+
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, ctx.Err()
+}
+
+//recordGCEpoch records a new epoch in the meta of store 's', it is also
+//recorded when the collection was cancelled after deleting chunks
+func recordGCEpoch(s Store) error {
+	ms, ok := s.(MetaStore)
+	if !ok {
+		return nil
+	}
+
+	v, err := time.Now().UTC().MarshalText()
+	if err != nil {
+		return fmt.Errorf("failed to encode gc epoch: %v", err)
+	}
+
+	err = putMetaContext(context.Background(), ms, GCEpochMetaName, v)
+	if err != nil {
+		return fmt.Errorf("failed to record gc epoch of store, indexes of it must be cleared: %w", err)
+	}
+
+	return nil
+}
+
+//gcEpoch returns the epoch that store 's' recorded when garbage was last
+//collected from it, it is empty if it never was
+func gcEpoch(ctx context.Context, s Store) (epoch []byte, err error) {
+	ms, ok := s.(MetaStore)
+	if !ok {
+		return nil, nil
+	}
+
+	epoch, err = getMetaContext(ctx, ms, GCEpochMetaName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get gc epoch of store: %w", err)
+	}
+
+	return epoch, nil
+}
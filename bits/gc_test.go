@@ -0,0 +1,133 @@
+package bits_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestGC(t *testing.T) {
+	stores := []struct {
+		name   string
+		create func(t *testing.T) bits.Config
+	}{
+		{"mem", func(t *testing.T) bits.Config {
+			return withStore(t, defaultConf(t, secret), bitsstore.NewMemStore())
+		}},
+		{"bolt", func(t *testing.T) bits.Config {
+			return withTmpBoltStore(t, defaultConf(t, secret))
+		}},
+		{"dir", func(t *testing.T) bits.Config {
+			dir, err := ioutil.TempDir("", "bits_gc_")
+			if err != nil {
+				t.Fatalf("failed to create temp dir: %v", err)
+			}
+
+			store, err := bitsstore.NewDirStore(dir)
+			if err != nil {
+				t.Fatalf("failed to create dir store: %v", err)
+			}
+
+			return withStore(t, defaultConf(t, secret), store)
+		}},
+		{"s3", func(t *testing.T) bits.Config {
+			conf := withS3Remote(t, defaultConf(t, secret), nil)
+			return withStore(t, conf, conf.Stores["remote"])
+		}},
+	}
+
+	cases := []struct {
+		name    string
+		dryRun  bool
+		grace   time.Duration
+		deleted bool
+	}{
+		{"dry_run", true, 0, false},
+		{"within_grace_period", false, time.Hour, false},
+		{"collect", false, 0, true},
+	}
+
+	for _, s := range stores {
+		for _, c := range cases {
+			t.Run(s.name+"_"+c.name, func(t *testing.T) {
+				conf := s.create(t)
+				live, dead := bitskeys.NewMemIterator(), bitskeys.NewMemIterator()
+				for _, kw := range []*bitskeys.MemIterator{live, dead} {
+					err := bits.Put(randBytesInput(bytes.NewReader(randb(512*1024)), secret), kw, conf)
+					if err != nil {
+						t.Fatalf("failed to put: %v", err)
+					}
+				}
+
+				conf.GCDryRun = c.dryRun
+				conf.GCGracePeriod = c.grace
+				stats, err := bits.GC(live, conf)
+				if err != nil {
+					t.Fatalf("failed to collect garbage: %v", err)
+				}
+
+				if stats.Live != int64(len(live.Keys)) || stats.Kept != int64(len(live.Keys)) {
+					t.Errorf("expected %d live keys to be kept, got: %+v", len(live.Keys), stats)
+				}
+
+				if c.grace > 0 && (stats.Young != int64(len(dead.Keys)) || stats.Deleted != 0) {
+					t.Errorf("expected %d young chunks to be kept, got: %+v", len(dead.Keys), stats)
+				}
+
+				if c.grace == 0 && (stats.Deleted != int64(len(dead.Keys)) || stats.Reclaimed < 512*1024) {
+					t.Errorf("expected %d chunks of at least 512KiB to be reclaimed, got: %+v", len(dead.Keys), stats)
+				}
+
+				live.Reset()
+				if err = bits.Get(live, bytes.NewBuffer(nil), conf); err != nil {
+					t.Errorf("expected live keys to remain available, got: %v", err)
+				}
+
+				err = bits.Get(dead, bytes.NewBuffer(nil), conf)
+				if c.deleted != (err != nil) {
+					t.Errorf("expected unreachable keys to be deleted: %v, got: %v", c.deleted, err)
+				}
+			})
+		}
+	}
+}
+
+func TestGCFailures(t *testing.T) {
+	store := bitsstore.NewMemStore()
+	conf := withStore(t, defaultConf(t, secret), store)
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewReader(randb(1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	cases := []struct {
+		name        string
+		kr          bits.KeyReader
+		store       bits.Store
+		expectedErr string
+	}{
+		{"failing_key_reader", &failingKeyIterator{keys}, store, "iterator_failure"},
+		{"store_cannot_list", bitskeys.NewMemIterator(), &emptyStore{}, "cannot list its chunks"},
+		{"store_cannot_delete", bitskeys.NewMemIterator(), struct{ bits.ListStore }{store}, "cannot delete chunks"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := bits.GC(c.kr, withStore(t, defaultConf(t, secret), c.store))
+			if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+				t.Errorf("expected error '%s', got: %v", c.expectedErr, err)
+			}
+
+			if len(store.Chunks) != len(keys.Keys) {
+				t.Errorf("expected no chunks to be deleted, got %d of %d chunks", len(store.Chunks), len(keys.Keys))
+			}
+		})
+	}
+}
@@ -1,9 +1,9 @@
 package bits
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"os"
 )
 
 //Get will read and decrypt chunks for keys provided by the key reader and write
@@ -11,6 +11,13 @@ import (
 //fetched concurrently (locally or remote) but are guaranteed to arrive in
 //order to writer 'cw' for assembly in the original format
 func Get(kr KeyReader, cw ChunkWriter, conf Config) error {
+	return GetContext(context.Background(), kr, cw, conf)
+}
+
+//GetContext is like Get but stops reading keys and aborts all work when
+//the context is cancelled or its deadline exceeds. It only returns when
+//all concurrent work has stopped.
+func GetContext(ctx context.Context, kr KeyReader, cw ChunkWriter, conf Config) error {
 
 	//result of working the item
 	type result struct {
@@ -26,43 +33,24 @@ func Get(kr KeyReader, cw ChunkWriter, conf Config) error {
 		pos   int64
 	}
 
+	//when returning early, cancel and wait for in-flight work to stop
+	wg := &workGroup{}
+	defer wg.StopAndWait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	//work is run concurrently
-	srcs := conf.Stores.GetSrcs()
+	srcs := conf.Stores.GetSrcs(conf.GetOrder...)
 	work := func(it *item) {
 
-		//ask each key container if it has one
-		var chunk []byte
-		var err error
-		for _, g := range srcs {
-			if g == nil {
-				continue
-			}
-
-			chunk, err = g.Get(it.key)
-			if err != nil {
-				continue
-			}
-
-			break
-		}
-
+		sealed, err := fetchChunk(ctx, srcs, it.key, conf.HedgeDelay)
 		if err != nil {
-			if os.IsNotExist(err) {
-				it.resCh <- &result{nil, ErrNoSuchKey}
-				return
-			}
-
-			it.resCh <- &result{nil, fmt.Errorf("failed to find key '%s': %v", it.key, err)}
-			return
-		}
-
-		if len(chunk) < conf.AEAD.NonceSize() {
-			it.resCh <- &result{nil, fmt.Errorf("encrypted chunk is too small (must be at least %d long): authentication failed", conf.AEAD.NonceSize())}
+			it.resCh <- &result{nil, err}
 			return
 		}
 
 		res := &result{}
-		res.chunk, res.err = conf.AEAD.Open(nil, chunk[:conf.AEAD.NonceSize()], chunk[conf.AEAD.NonceSize():], nil)
+		res.chunk, res.err = openChunk(conf, it.key, sealed)
 		it.resCh <- res
 	}
 
@@ -71,13 +59,13 @@ func Get(kr KeyReader, cw ChunkWriter, conf Config) error {
 	go func() {
 		defer close(itemCh)
 		pos := int64(0)
-		for {
+		for ctx.Err() == nil {
 			k, err := kr.Read()
 			if err != nil {
 				if err != io.EOF {
-					itemCh <- &item{
-						key: k,
-						err: fmt.Errorf("failed to iterate into next key: %v", err),
+					select {
+					case itemCh <- &item{key: k, err: fmt.Errorf("failed to iterate into next key: %v", err)}:
+					case <-ctx.Done():
 					}
 				}
 
@@ -87,11 +75,19 @@ func Get(kr KeyReader, cw ChunkWriter, conf Config) error {
 			it := &item{
 				pos:   pos,
 				key:   k,
-				resCh: make(chan *result),
+				resCh: make(chan *result, 1),
+			}
+
+			if !wg.Go(func() { work(it) }) { //create work
+				return
+			}
+
+			select {
+			case itemCh <- it: //send to fan-in thread for syncing results
+			case <-ctx.Done():
+				return
 			}
 
-			go work(it)  //create work
-			itemCh <- it //send to fan-in thread for syncing results
 			pos++
 		}
 	}()
@@ -114,7 +110,14 @@ func Get(kr KeyReader, cw ChunkWriter, conf Config) error {
 
 		res := <-it.resCh
 		if res.err != nil {
-			return fmt.Errorf("failed to work chunk '%s': %v", it.key, res.err)
+			return fmt.Errorf("failed to work chunk '%s': %w", it.key, res.err)
+		}
+
+		if cv, ok := kr.(ChunkVerifier); ok {
+			err := cv.VerifyChunk(it.key, res.chunk)
+			if err != nil {
+				return fmt.Errorf("failed to verify chunk '%s': %v", it.key, err)
+			}
 		}
 
 		_, err := cw.Write(res.chunk)
@@ -125,5 +128,17 @@ func Get(kr KeyReader, cw ChunkWriter, conf Config) error {
 		lastpos = it.pos
 	}
 
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	//verify the output as a whole, e.g: its length and digest
+	if cv, ok := kr.(ChunkVerifier); ok {
+		err := cv.Verify()
+		if err != nil {
+			return fmt.Errorf("failed to verify output: %v", err)
+		}
+	}
+
 	return nil
 }
@@ -2,10 +2,14 @@ package bits_test
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/advanderveer/libchunk/bits"
 	"github.com/advanderveer/libchunk/bits/keys"
@@ -193,3 +197,108 @@ func TestGetFromLocal(t *testing.T) {
 	}
 
 }
+
+func TestGetSrcsOrder(t *testing.T) {
+	mem, bolt := bitsstore.NewMemStore(), withTmpBoltStore(t, defaultConf(t, secret)).Stores["local"]
+	remote := bitsstore.NewS3Remote("http", "localhost", "", "", "")
+	other := &emptyStore{}
+	sm := bits.StoreMap{"remote": remote, "local": bolt, "b": other, "a": mem, "c": other}
+	for i := 0; i < 10; i++ {
+		srcs := sm.GetSrcs()
+		if len(srcs) != 5 || srcs[0] != bits.Store(mem) || srcs[1] != bolt || srcs[2] != bits.Store(remote) || srcs[3] != bits.Store(other) {
+			t.Fatalf("expected stores in order of latency, role and name, got: %v", srcs)
+		}
+	}
+
+	srcs := sm.GetSrcs("c", "remote", "b", "missing", "a", "c")
+	if len(srcs) != 4 || srcs[0] != bits.Store(other) || srcs[1] != bits.Store(remote) || srcs[2] != bits.Store(other) || srcs[3] != bits.Store(mem) {
+		t.Fatalf("expected only the named stores in the order given, got: %v", srcs)
+	}
+}
+
+//slowStore answers gets after a delay unless the context is done first
+type slowStore struct {
+	bits.Store
+	delay time.Duration
+}
+
+func (s *slowStore) Get(k bits.K) (chunk []byte, err error) {
+	return s.GetContext(context.Background(), k)
+}
+
+func (s *slowStore) GetContext(ctx context.Context, k bits.K) (chunk []byte, err error) {
+	select {
+	case <-time.After(s.delay):
+		return s.Store.Get(k)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestHedgedGet(t *testing.T) {
+	store := bitsstore.NewMemStore()
+	data := randb(1024 * 1024)
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewReader(data), secret), keys, withStore(t, defaultConf(t, secret), store))
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		local bits.Store
+	}{
+		{"slow_first_store", &slowStore{store, time.Minute}},
+		{"failing_first_store", &failingStore{}},
+		{"empty_first_store", &emptyStore{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := withRemote(t, withStore(t, defaultConf(t, secret), c.local), store)
+			conf.HedgeDelay = 10 * time.Millisecond
+			keys.Reset()
+			output := bytes.NewBuffer(nil)
+			start := time.Now()
+			err := bits.Get(keys, output, conf)
+			if err != nil || !bytes.Equal(output.Bytes(), data) {
+				t.Fatalf("expected output to equal input (%v)", err)
+			}
+
+			if time.Since(start) > 10*time.Second {
+				t.Errorf("expected the second store to be asked in time, took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestGetTransientFailure(t *testing.T) {
+	cases := []struct {
+		name      string
+		status    int
+		transient bool
+	}{
+		{"slow_down", http.StatusServiceUnavailable, true},
+		{"forbidden", http.StatusForbidden, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(c.status)
+			}))
+
+			defer svr.Close()
+			remote := bitsstore.NewS3Remote("http", strings.TrimPrefix(svr.URL, "http://"), "", "", "")
+			remote.MaxRetries = 2
+			remote.RetryBaseDelay = time.Millisecond
+
+			keys := bitskeys.NewPopulatedMemIterator([]bits.K{{0x01}})
+			conf := withRemote(t, withStore(t, defaultConf(t, secret), &emptyStore{}), remote)
+			err := bits.Get(keys, bytes.NewBuffer(nil), conf)
+			if err == nil || bits.IsTransient(err) != c.transient {
+				t.Errorf("expected error to be transient: %v, got: %v", c.transient, err)
+			}
+		})
+	}
+}
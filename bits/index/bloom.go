@@ -0,0 +1,179 @@
+package bitsindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/advanderveer/libchunk/bits"
+)
+
+//BloomIndexVersion is the version of the format a bloom index is written in
+const BloomIndexVersion = 1
+
+//MaxBloomIndexBits is the largest number of bits a bloom index can have,
+//an 8GiB filter that holds billions of keys
+const MaxBloomIndexBits = 1 << 36
+
+//bloomReadWords is the number of words that are read at once, such that a
+//corrupt number of bits doesn't allocate more than the input holds
+const bloomReadWords = 1 << 16
+
+//BloomIndexMagic starts each bloom index that is written, it is followed by
+//the version
+var BloomIndexMagic = []byte("BITSBLOOM")
+
+//BloomIndex is a bloom filter of keys, it takes about 10 bits per key for
+//a false positive rate of 1% instead of the over 60 bytes a MemIndex takes.
+//Has may return true for keys that were never written, at the configured
+//rate, but never returns false for a key that was. Keys are the output of
+//a cryptographic hash, their bytes are used as the filter's hashes. It is
+//safe for concurrent use
+type BloomIndex struct {
+	mu    sync.RWMutex
+	words []uint64
+	m     uint64 //number of bits
+	k     uint64 //number of hashes per key
+	n     uint64 //number of keys written
+}
+
+//NewBloomIndex creates an empty bloom index that holds 'capacity' keys with
+//false positive rate 'rate'
+func NewBloomIndex(capacity uint64, rate float64) (idx *BloomIndex, err error) {
+	if capacity < 1 || rate <= 0 || rate >= 1 {
+		return nil, fmt.Errorf("invalid bloom index, expected a positive capacity and a rate between 0 and 1, got: %d, %v", capacity, rate)
+	}
+
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Ceil(float64(m) / float64(capacity) * math.Ln2))
+	m = (m + 63) / 64 * 64
+	if m > MaxBloomIndexBits {
+		return nil, fmt.Errorf("invalid bloom index, it would take %d bits while at most %d are supported", m, uint64(MaxBloomIndexBits))
+	}
+
+	return &BloomIndex{words: make([]uint64, m/64), m: m, k: k}, nil
+}
+
+//positions calls 'fn' with each bit of key 'k', it stops when 'fn' returns
+//false. Bits are picked by double hashing with two halves of the key
+func (idx *BloomIndex) positions(k bits.K, fn func(pos uint64) bool) {
+	h1 := binary.LittleEndian.Uint64(k[0:8])
+	h2 := binary.LittleEndian.Uint64(k[8:16]) | 1
+	for i := uint64(0); i < idx.k; i++ {
+		if !fn((h1 + i*h2) % idx.m) {
+			return
+		}
+	}
+}
+
+//Write adds key 'k' to the index
+func (idx *BloomIndex) Write(k bits.K) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.positions(k, func(pos uint64) bool {
+		idx.words[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+
+	idx.n++
+	return nil
+}
+
+//Has returns whether key 'k' was probably written to the index
+func (idx *BloomIndex) Has(k bits.K) (ok bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ok = true
+	idx.positions(k, func(pos uint64) bool {
+		ok = idx.words[pos/64]&(1<<(pos%64)) != 0
+		return ok
+	})
+
+	return ok
+}
+
+//FalsePositiveRate estimates the probability that Has returns true for a
+//key that was never written, given the number of keys that were written
+func (idx *BloomIndex) FalsePositiveRate() float64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return math.Pow(1-math.Exp(-float64(idx.k*idx.n)/float64(idx.m)), float64(idx.k))
+}
+
+//WriteTo writes the index to 'w' such that it can be read again with
+//ReadBloomIndex
+func (idx *BloomIndex) WriteTo(w io.Writer) (n int64, err error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	buf := bytes.NewBuffer(nil)
+	buf.Write(BloomIndexMagic)
+	buf.WriteByte(BloomIndexVersion)
+	for _, v := range []uint64{idx.m, idx.k, idx.n} {
+		binary.Write(buf, binary.BigEndian, v)
+	}
+
+	n, err = buf.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+
+	bw := bufio.NewWriter(w)
+	err = binary.Write(bw, binary.BigEndian, idx.words)
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	if err != nil {
+		return n, fmt.Errorf("failed to write bloom filter: %v", err)
+	}
+
+	return n + int64(len(idx.words)*8), nil
+}
+
+//ReadBloomIndex reads an index that was written by WriteTo
+func ReadBloomIndex(r io.Reader) (idx *BloomIndex, err error) {
+	header := make([]byte, len(BloomIndexMagic)+1)
+	_, err = io.ReadFull(r, header)
+	if err != nil || !bytes.HasPrefix(header, BloomIndexMagic) {
+		return nil, fmt.Errorf("input doesn't start with the bloom index header")
+	}
+
+	if v := header[len(BloomIndexMagic)]; v != BloomIndexVersion {
+		return nil, fmt.Errorf("bloom index has version %d, only version %d is supported", v, BloomIndexVersion)
+	}
+
+	idx = &BloomIndex{}
+	br := bufio.NewReader(r)
+	for _, v := range []*uint64{&idx.m, &idx.k, &idx.n} {
+		err = binary.Read(br, binary.BigEndian, v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bloom index parameters: %v", err)
+		}
+	}
+
+	if idx.m == 0 || idx.m%64 != 0 || idx.m > MaxBloomIndexBits || idx.k == 0 {
+		return nil, fmt.Errorf("bloom index has invalid parameters: %d bits, %d hashes", idx.m, idx.k)
+	}
+
+	//the filter is read in blocks, it grows only as far as the input goes
+	for n := idx.m / 64; uint64(len(idx.words)) < n; {
+		size := n - uint64(len(idx.words))
+		if size > bloomReadWords {
+			size = bloomReadWords
+		}
+
+		block := make([]uint64, size)
+		err = binary.Read(br, binary.BigEndian, block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bloom filter: %v", err)
+		}
+
+		idx.words = append(idx.words, block...)
+	}
+
+	return idx, nil
+}
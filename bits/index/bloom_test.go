@@ -0,0 +1,93 @@
+package bitsindex_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/index"
+)
+
+func key(i uint64) bits.K {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return bits.K(sha256.Sum256(b))
+}
+
+func TestBloomIndex(t *testing.T) {
+	cases := []struct {
+		name     string
+		capacity uint64
+		rate     float64
+	}{
+		{"one_percent", 10000, 0.01},
+		{"one_in_a_thousand", 10000, 0.001},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			idx, err := bitsindex.NewBloomIndex(c.capacity, c.rate)
+			if err != nil {
+				t.Fatalf("failed to create index: %v", err)
+			}
+
+			for i := uint64(0); i < c.capacity; i++ {
+				idx.Write(key(i))
+			}
+
+			buf := bytes.NewBuffer(nil)
+			_, err = idx.WriteTo(buf)
+			if err != nil {
+				t.Fatalf("failed to write index: %v", err)
+			}
+
+			idx, err = bitsindex.ReadBloomIndex(buf)
+			if err != nil {
+				t.Fatalf("failed to read index: %v", err)
+			}
+
+			for i := uint64(0); i < c.capacity; i++ {
+				if !idx.Has(key(i)) {
+					t.Fatalf("expected index to have key %d", i)
+				}
+			}
+
+			fps := 0
+			for i := c.capacity; i < 11*c.capacity; i++ {
+				if idx.Has(key(i)) {
+					fps++
+				}
+			}
+
+			if rate := float64(fps) / float64(10*c.capacity); rate > 2*c.rate || idx.FalsePositiveRate() > 1.2*c.rate {
+				t.Errorf("expected false positive rate of about %v, got %v (estimated %v)", c.rate, rate, idx.FalsePositiveRate())
+			}
+		})
+	}
+
+	_, err := bitsindex.NewBloomIndex(10, 1)
+	if err == nil {
+		t.Errorf("expected error for invalid rate")
+	}
+
+	_, err = bitsindex.ReadBloomIndex(strings.NewReader("foo"))
+	if err == nil || !strings.Contains(err.Error(), "header") {
+		t.Errorf("expected header error, got: %v", err)
+	}
+
+	for _, m := range []uint64{bitsindex.MaxBloomIndexBits + 64, bitsindex.MaxBloomIndexBits} {
+		hdr := append(append([]byte{}, bitsindex.BloomIndexMagic...), bitsindex.BloomIndexVersion)
+		for _, v := range []uint64{m, 7, 0} {
+			hdr = append(hdr, make([]byte, 8)...)
+			binary.BigEndian.PutUint64(hdr[len(hdr)-8:], v)
+		}
+
+		_, err = bitsindex.ReadBloomIndex(bytes.NewReader(hdr))
+		if err == nil {
+			t.Errorf("expected error for a corrupt index of %d bits", m)
+		}
+	}
+}
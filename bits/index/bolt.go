@@ -0,0 +1,392 @@
+package bitsindex
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	//BoltIndexBucket is the name of the bucket that holds a nested bucket
+	//for the index of each remote
+	BoltIndexBucket = []byte("indexes")
+
+	//names of the buckets that alternately hold the keys, the value that
+	//names the bucket with the current keys and the values with the time
+	//and gc epoch of the last sync, in the bucket of each index
+	boltIndexKeys     = []byte("keys")
+	boltIndexNextKeys = []byte("keys-next")
+	boltIndexCurrent  = []byte("current")
+	boltIndexSynced   = []byte("synced")
+	boltIndexEpoch    = []byte("epoch")
+)
+
+//DefaultBoltIndexBatchSize is the number of keys that are written in one
+//transaction, e.g: while a remote is listed
+const DefaultBoltIndexBatchSize = 1000
+
+//BoltIndex persists the keys that a remote holds in a bolt database, such
+//that they don't have to be listed again for each move. It remembers when
+//the remote was last listed and is fresh until it is older than MaxAge, or
+//until garbage is collected from the remote.
+//When the remote is listed again the keys are written to a new bucket that
+//replaces the keys of the index once the listing completes, such that keys
+//of chunks that were deleted from the remote are dropped. Keys are buffered
+//and written in batches, it is safe for concurrent use
+type BoltIndex struct {
+	db *bolt.DB
+	id []byte
+
+	mu      sync.Mutex
+	pending map[bits.K]struct{}
+	syncing bool
+
+	//how long the index is fresh after the remote was listed, it is
+	//never fresh if zero
+	MaxAge time.Duration
+
+	//number of buffered keys at which they are written to the database
+	BatchSize int
+}
+
+//NewBoltIndex opens the index of remote 'id' in bolt database 'db', the
+//database can be shared with a BoltStore
+func NewBoltIndex(db *bolt.DB, id string, maxAge time.Duration) (idx *BoltIndex, err error) {
+	if id == "" {
+		return nil, fmt.Errorf("index requires a non-empty remote id")
+	}
+
+	idx = &BoltIndex{
+		db:        db,
+		id:        []byte(id),
+		pending:   map[bits.K]struct{}{},
+		MaxAge:    maxAge,
+		BatchSize: DefaultBoltIndexBatchSize,
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(BoltIndexBucket)
+		if err != nil {
+			return err
+		}
+
+		b, err = b.CreateBucketIfNotExists(idx.id)
+		if err != nil {
+			return err
+		}
+
+		_, err = b.CreateBucketIfNotExists(boltIndexKeys)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index buckets: %v", err)
+	}
+
+	return idx, nil
+}
+
+func (idx *BoltIndex) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	b := tx.Bucket(BoltIndexBucket)
+	if b == nil {
+		return nil, fmt.Errorf("index bucket '%s' must first be created", string(BoltIndexBucket))
+	}
+
+	b = b.Bucket(idx.id)
+	if b == nil {
+		return nil, fmt.Errorf("index of remote '%s' must first be created", string(idx.id))
+	}
+
+	return b, nil
+}
+
+//current returns the name of the bucket with the current keys and the name
+//of the bucket that a sync fills
+func (idx *BoltIndex) current(b *bolt.Bucket) (cur, next []byte) {
+	if string(b.Get(boltIndexCurrent)) == string(boltIndexNextKeys) {
+		return boltIndexNextKeys, boltIndexKeys
+	}
+
+	return boltIndexKeys, boltIndexNextKeys
+}
+
+//Write adds key 'k' to the index, it is written to the database once the
+//batch is full or the index is flushed
+func (idx *BoltIndex) Write(k bits.K) error {
+	idx.mu.Lock()
+	idx.pending[k] = struct{}{}
+	full := len(idx.pending) >= idx.BatchSize
+	idx.mu.Unlock()
+	if !full {
+		return nil
+	}
+
+	return idx.Flush()
+}
+
+//Flush writes all buffered keys to the database
+func (idx *BoltIndex) Flush() error {
+	idx.mu.Lock()
+	pending := idx.pending
+	syncing := idx.syncing
+	idx.pending = map[bits.K]struct{}{}
+	idx.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := idx.db.Update(func(tx *bolt.Tx) error {
+		b, err := idx.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		//keys go to the bucket of the sync, unless it was marked since
+		cur, next := idx.current(b)
+		keys := b.Bucket(cur)
+		if syncing && b.Bucket(next) != nil {
+			cur, keys = next, b.Bucket(next)
+		}
+
+		if keys == nil {
+			return fmt.Errorf("keys bucket '%s' of the index must first be created", string(cur))
+		}
+
+		for k := range pending {
+			err = keys.Put(k[:], []byte{})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to write keys to index: %v", err)
+	}
+
+	return nil
+}
+
+//Has returns whether key 'k' is in the index, a key is considered absent
+//if the index cannot be read. During a sync keys that were listed already
+//are present as well as the keys of the previous sync
+func (idx *BoltIndex) Has(k bits.K) (ok bool) {
+	idx.mu.Lock()
+	_, ok = idx.pending[k]
+	syncing := idx.syncing
+	idx.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	idx.db.View(func(tx *bolt.Tx) error {
+		b, err := idx.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		cur, next := idx.current(b)
+		if keys := b.Bucket(cur); keys != nil {
+			ok = keys.Get(k[:]) != nil
+		}
+
+		if keys := b.Bucket(next); !ok && syncing && keys != nil {
+			ok = keys.Get(k[:]) != nil
+		}
+
+		return nil
+	})
+
+	return ok
+}
+
+//Count returns the number of keys in the index, including buffered keys
+//that are not yet written
+func (idx *BoltIndex) Count() (n int, err error) {
+	err = idx.Flush()
+	if err != nil {
+		return 0, err
+	}
+
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		b, err := idx.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		cur, _ := idx.current(b)
+		if keys := b.Bucket(cur); keys != nil {
+			n = keys.Stats().KeyN
+		}
+
+		return nil
+	})
+
+	return n, err
+}
+
+//Synced returns when the remote was last listed completely, it returns the
+//zero time if it never was
+func (idx *BoltIndex) Synced() (t time.Time, err error) {
+	err = idx.db.View(func(tx *bolt.Tx) error {
+		b, err := idx.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if v := b.Get(boltIndexSynced); v != nil {
+			return t.UnmarshalText(v)
+		}
+
+		return nil
+	})
+
+	return t, err
+}
+
+//Fresh returns whether the remote was listed less than MaxAge ago and its
+//gc epoch is still 'epoch'
+func (idx *BoltIndex) Fresh(epoch []byte) bool {
+	t, err := idx.Synced()
+	if err != nil || t.IsZero() || time.Since(t) >= idx.MaxAge {
+		return false
+	}
+
+	same := false
+	idx.db.View(func(tx *bolt.Tx) error {
+		b, err := idx.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		same = bytes.Equal(b.Get(boltIndexEpoch), epoch)
+		return nil
+	})
+
+	return same
+}
+
+//StartSync starts listing the remote again, keys that are written until
+//the sync is marked replace the keys of the index
+func (idx *BoltIndex) StartSync() error {
+	err := idx.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		b, err := idx.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		_, next := idx.current(b)
+		err = b.DeleteBucket(next)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		_, err = b.CreateBucket(next)
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to start sync of index: %v", err)
+	}
+
+	idx.mu.Lock()
+	idx.syncing = true
+	idx.mu.Unlock()
+	return nil
+}
+
+//MarkSynced flushes the index and records that the remote was listed
+//completely at time 't' while its gc epoch was 'epoch'. If a sync was started
+//the keys that were written since replace the keys of the index
+func (idx *BoltIndex) MarkSynced(t time.Time, epoch []byte) error {
+	err := idx.Flush()
+	if err != nil {
+		return err
+	}
+
+	v, err := t.MarshalText()
+	if err != nil {
+		return fmt.Errorf("failed to encode sync time: %v", err)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	err = idx.db.Update(func(tx *bolt.Tx) error {
+		b, err := idx.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		if idx.syncing {
+			cur, next := idx.current(b)
+			err = b.DeleteBucket(cur)
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+
+			err = b.Put(boltIndexCurrent, next)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(epoch) == 0 && b.Get(boltIndexEpoch) != nil {
+			err = b.Delete(boltIndexEpoch)
+		} else if len(epoch) > 0 {
+			err = b.Put(boltIndexEpoch, epoch)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return b.Put(boltIndexSynced, v)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	idx.syncing = false
+	return nil
+}
+
+//Clear removes all keys and the sync time from the index, for example when
+//chunks were deleted from the remote
+func (idx *BoltIndex) Clear() error {
+	idx.mu.Lock()
+	idx.pending = map[bits.K]struct{}{}
+	idx.syncing = false
+	idx.mu.Unlock()
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltIndexBucket)
+		if b == nil {
+			return fmt.Errorf("index bucket '%s' must first be created", string(BoltIndexBucket))
+		}
+
+		err := b.DeleteBucket(idx.id)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		b, err = b.CreateBucket(idx.id)
+		if err != nil {
+			return err
+		}
+
+		_, err = b.CreateBucket(boltIndexKeys)
+		return err
+	})
+}
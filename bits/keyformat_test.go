@@ -0,0 +1,112 @@
+package bits_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+)
+
+func readAllKeys(t *testing.T, kr bits.KeyReader) (keys []bits.K) {
+	for {
+		k, err := kr.Read()
+		if err == io.EOF {
+			return keys
+		} else if err != nil {
+			t.Fatalf("failed to read key: %v", err)
+		}
+
+		keys = append(keys, k)
+	}
+}
+
+func TestKeyFormats(t *testing.T) {
+	keys := []bits.K{{0x01}, {0x02}, {0x03}}
+	for _, format := range bitskeys.SupportedKeyFormats {
+		t.Run(format, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "bits_keys_")
+			if err != nil {
+				t.Fatalf("failed to create temp file: %v", err)
+			}
+
+			defer os.Remove(f.Name())
+			defer f.Close()
+			kw, err := bitskeys.CreateKeyWriter(format, f)
+			if err != nil {
+				t.Fatalf("failed to create key writer: %v", err)
+			}
+
+			for _, k := range keys {
+				if err = kw.Write(k); err != nil {
+					t.Fatalf("failed to write key: %v", err)
+				}
+			}
+
+			if c, ok := kw.(io.Closer); ok {
+				if err = c.Close(); err != nil {
+					t.Fatalf("failed to close key writer: %v", err)
+				}
+			}
+
+			if _, err = f.Seek(0, io.SeekStart); err != nil {
+				t.Fatalf("failed to seek: %v", err)
+			}
+
+			kr, err := bitskeys.CreateKeyReader(format, f)
+			if err != nil {
+				t.Fatalf("failed to create key reader: %v", err)
+			}
+
+			first := readAllKeys(t, kr)
+			kr.Reset()
+			second := readAllKeys(t, kr)
+			if len(first) != len(keys) || len(second) != len(keys) || first[2] != keys[2] || second[0] != keys[0] {
+				t.Errorf("expected keys to be read twice, got: %v and %v", first, second)
+			}
+		})
+	}
+}
+
+func TestBinaryKeyFormat(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	kw := bitskeys.NewBinaryKeyWriter(buf)
+	if err := kw.Write(bits.K{0x01}); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	if buf.Len() != len(bitskeys.BinaryKeyMagic)+1+bits.KeySize {
+		t.Errorf("expected a header and a raw key, got %d bytes", buf.Len())
+	}
+
+	cases := []struct {
+		name        string
+		input       []byte
+		expectedErr string
+	}{
+		{"no_header", []byte("foo"), "doesn't start with the binary key header"},
+		{"other_version", append(append([]byte{}, bitskeys.BinaryKeyMagic...), 2), "has version 2"},
+		{"partial_key", buf.Bytes()[:buf.Len()-1], "ends with a partial key"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := bitskeys.NewBinaryKeyReader(bytes.NewReader(c.input)).Read()
+			if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+				t.Errorf("expected error '%s', got: %v", c.expectedErr, err)
+			}
+		})
+	}
+
+	//pipes cannot seek, a reset must not go unnoticed
+	kr := bitskeys.NewBinaryKeyReader(bytes.NewBuffer(buf.Bytes()))
+	readAllKeys(t, kr)
+	kr.Reset()
+	if _, err := kr.Read(); err == nil || !strings.Contains(err.Error(), "cannot seek") {
+		t.Errorf("expected reset of a pipe to fail, got: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package bits
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+)
+
+//KeyHashMode identifies how chunk keys are computed from plaintext chunks
+type KeyHashMode string
+
+const (
+	//PlainKeyHash computes keys as the sha256 hash of the plaintext chunk,
+	//anyone that can list keys can check if a known chunk is stored
+	PlainKeyHash = KeyHashMode("sha256")
+
+	//KeyedKeyHash computes keys as a HMAC-SHA256 of the plaintext chunk
+	//with a key that is derived from the secret
+	KeyedKeyHash = KeyHashMode("hmac-sha256")
+)
+
+//KeyHashMetaName is the name under which a MetaStore records the mode of
+//the keys that it holds
+const KeyHashMetaName = "key_hash"
+
+//SupportedKeyHashes holds identifiers for all supported key hash modes
+var SupportedKeyHashes = []string{string(KeyedKeyHash), string(PlainKeyHash)}
+
+//labels make sure keys that are derived from the secret differ from the
+//encryption key and from each other
+var (
+	keyHashLabel   = []byte("bits key hash")
+	nonceHashLabel = []byte("bits nonce hash")
+	gearLabel      = []byte("bits gear table")
+)
+
+//deriveKey derives a key for the purpose described by 'label' from 'secret'
+func deriveKey(secret Secret, label []byte) []byte {
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write(label)
+	return mac.Sum(nil)
+}
+
+//ParseKeyHashMode turns a key hash identifier into a mode
+func ParseKeyHashMode(name string) (mode KeyHashMode, err error) {
+	for _, supported := range SupportedKeyHashes {
+		if name == supported {
+			return KeyHashMode(name), nil
+		}
+	}
+
+	return mode, fmt.Errorf("key hash '%s' is not supported, available key hashes are: %v", name, SupportedKeyHashes)
+}
+
+//NewKeyHash returns the key hash function for 'mode', keyed hashes use a
+//key that is derived from 'secret'
+func NewKeyHash(mode KeyHashMode, secret Secret) (kh KeyHash, err error) {
+	switch mode {
+	case PlainKeyHash:
+		return func(b []byte) K {
+			return sha256.Sum256(b)
+		}, nil
+	case KeyedKeyHash:
+		key := deriveKey(secret, keyHashLabel)
+		return func(b []byte) (k K) {
+			mac := hmac.New(sha256.New, key)
+			mac.Write(b)
+			copy(k[:], mac.Sum(nil))
+			return k
+		}, nil
+	default:
+		return nil, fmt.Errorf("key hash '%s' is not supported, available key hashes are: %v", mode, SupportedKeyHashes)
+	}
+}
+
+//NonceHash derives the nonce of a chunk from its envelope header and the
+//(compressed) plaintext that is encrypted, it makes encryption deterministic
+type NonceHash func(header, plaintext []byte) []byte
+
+//NewNonceHash returns a nonce hash that computes a HMAC-SHA256 over the header
+//and plaintext with a key that is derived from 'secret'. Like AES-GCM-SIV
+//distinct plaintexts get distinct nonces, such that a nonce is never reused
+//for a different plaintext, while identical chunks are stored as identical
+//bytes. This reveals which stored chunks are equal, nothing more
+func NewNonceHash(secret Secret) NonceHash {
+	key := deriveKey(secret, nonceHashLabel)
+	return func(header, plaintext []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(header)
+		mac.Write(plaintext)
+		return mac.Sum(nil)
+	}
+}
+
+//errStopListing stops listing the chunks of a store after the first chunk
+var errStopListing = errors.New("stop listing")
+
+//StoreKeyHashMode returns the key hash mode that store 's' recorded. Stores
+//that hold chunks but recorded nothing were filled before modes were
+//recorded, their keys are plain. The mode is empty if the store cannot
+//record it, or if nothing was recorded and the store is empty or cannot
+//list its chunks
+func StoreKeyHashMode(ctx context.Context, s Store) (mode KeyHashMode, err error) {
+	ms, ok := s.(MetaStore)
+	if !ok {
+		return mode, nil
+	}
+
+	mode, err = recordedKeyHashMode(ctx, ms)
+	if err != nil || mode != "" {
+		return mode, err
+	}
+
+	return legacyKeyHashMode(ctx, s)
+}
+
+//recordedKeyHashMode returns the key hash mode that meta store 'ms'
+//recorded, it is empty if nothing was recorded
+func recordedKeyHashMode(ctx context.Context, ms MetaStore) (mode KeyHashMode, err error) {
+	v, err := getMetaContext(ctx, ms, KeyHashMetaName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return mode, nil
+		}
+
+		if ctx.Err() != nil {
+			return mode, ctx.Err()
+		}
+
+		return mode, fmt.Errorf("failed to get key hash of store: %w", err)
+	}
+
+	return KeyHashMode(bytes.TrimSpace(v)), nil
+}
+
+//legacyKeyHashMode returns the plain mode if store 's' holds any chunk, it
+//is used for stores that didn't record a mode. The mode is empty if the
+//store is empty or cannot list its chunks
+func legacyKeyHashMode(ctx context.Context, s Store) (mode KeyHashMode, err error) {
+	ls, ok := s.(ListStore)
+	if !ok {
+		return mode, nil
+	}
+
+	err = listContext(ctx, ls, func(info ChunkInfo) error {
+		mode = PlainKeyHash
+		return errStopListing
+	})
+
+	if err != nil && err != errStopListing {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		return "", fmt.Errorf("failed to list chunks to determine key hash of store: %w", err)
+	}
+
+	return mode, nil
+}
+
+//checkKeyHashMode makes sure store 's' only holds keys of 'mode', the mode
+//is recorded if the store is empty and didn't record one yet. A store that
+//holds keys from before modes were recorded only accepts plain keys, which
+//is then recorded. Nothing is checked if the mode is empty or if the store
+//cannot record it
+func checkKeyHashMode(ctx context.Context, s Store, mode KeyHashMode) error {
+	ms, ok := s.(MetaStore)
+	if !ok || mode == "" {
+		return nil
+	}
+
+	recorded, err := recordedKeyHashMode(ctx, ms)
+	if err != nil {
+		return err
+	}
+
+	if recorded == "" {
+		recorded, err = legacyKeyHashMode(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		if recorded == "" || recorded == mode {
+			err = putMetaContext(ctx, ms, KeyHashMetaName, []byte(mode))
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				return fmt.Errorf("failed to record key hash of store: %w", err)
+			}
+
+			return nil
+		}
+	}
+
+	if recorded != mode {
+		return fmt.Errorf("store holds keys that were hashed with '%s', not with '%s'", recorded, mode)
+	}
+
+	return nil
+}
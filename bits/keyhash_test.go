@@ -0,0 +1,119 @@
+package bits_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestKeyHash(t *testing.T) {
+	chunk := []byte("foo bar")
+	plain, err := bits.NewKeyHash(bits.PlainKeyHash, secret)
+	if err != nil {
+		t.Fatalf("failed to create plain key hash: %v", err)
+	}
+
+	if plain(chunk) != bits.K(sha256.Sum256(chunk)) {
+		t.Errorf("expected plain key to be the sha256 of the chunk")
+	}
+
+	keyed := defaultConf(t, secret).KeyHash
+	if keyed(chunk) == plain(chunk) {
+		t.Errorf("expected default key hash to not reveal the sha256 of the chunk")
+	}
+
+	if keyed(chunk) != keyed(chunk) {
+		t.Errorf("expected keyed hash to be deterministic")
+	}
+
+	other := secret
+	other[31] = 0x01
+	if defaultConf(t, other).KeyHash(chunk) == keyed(chunk) {
+		t.Errorf("expected keyed hash to depend on the secret")
+	}
+
+	_, err = bits.ParseKeyHashMode("md5")
+	if err == nil {
+		t.Errorf("expected unsupported key hash to fail parsing")
+	}
+}
+
+func TestKeyHashModeIsRecorded(t *testing.T) {
+	local := bitsstore.NewMemStore()
+	remote := bitsstore.NewMemStore()
+	conf := withRemote(t, withStore(t, defaultConf(t, secret), local), remote)
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewReader(randb(1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	if string(local.Meta[bits.KeyHashMetaName]) != string(bits.KeyedKeyHash) {
+		t.Errorf("expected store to record key hash, got: %q", local.Meta[bits.KeyHashMetaName])
+	}
+
+	err = bits.Move(keys, bitskeys.NewMemIterator(), conf)
+	if err != nil {
+		t.Fatalf("failed to move: %v", err)
+	}
+
+	if string(remote.Meta[bits.KeyHashMetaName]) != string(bits.KeyedKeyHash) {
+		t.Errorf("expected remote to take on key hash of local, got: %q", remote.Meta[bits.KeyHashMetaName])
+	}
+
+	plainConf := conf
+	plainConf.KeyHashMode = bits.PlainKeyHash
+	plainConf.KeyHash, _ = bits.NewKeyHash(bits.PlainKeyHash, secret)
+	err = bits.Put(randBytesInput(bytes.NewReader(randb(1024)), secret), bitskeys.NewMemIterator(), plainConf)
+	if err == nil || !strings.Contains(err.Error(), "hashed with 'hmac-sha256'") {
+		t.Errorf("expected putting plain keys into keyed store to fail, got: %v", err)
+	}
+
+	remote.Meta[bits.KeyHashMetaName] = []byte(bits.PlainKeyHash)
+	keys.Reset()
+	err = bits.Move(keys, bitskeys.NewMemIterator(), conf)
+	if err == nil || !strings.Contains(err.Error(), "hashed with 'sha256'") {
+		t.Errorf("expected moving keyed keys to a plain store to fail, got: %v", err)
+	}
+}
+
+func TestKeyHashModeOfLegacyStore(t *testing.T) {
+	legacy := bitsstore.NewMemStore()
+	legacy.Chunks[bits.K(sha256.Sum256([]byte("foo")))] = []byte("bar")
+	conf := withStore(t, defaultConf(t, secret), legacy)
+	mode, err := bits.StoreKeyHashMode(context.Background(), legacy)
+	if err != nil || mode != bits.PlainKeyHash {
+		t.Fatalf("expected a store with unrecorded chunks to hold plain keys, got: %q (%v)", mode, err)
+	}
+
+	err = bits.Put(randBytesInput(bytes.NewReader(randb(1024)), secret), bitskeys.NewMemIterator(), conf)
+	if err == nil || !strings.Contains(err.Error(), "hashed with 'sha256'") {
+		t.Errorf("expected putting keyed keys into a legacy store to fail, got: %v", err)
+	}
+
+	if _, ok := legacy.Meta[bits.KeyHashMetaName]; ok {
+		t.Errorf("expected refused put to record no key hash, got: %q", legacy.Meta[bits.KeyHashMetaName])
+	}
+
+	conf.KeyHashMode = bits.PlainKeyHash
+	conf.KeyHash, _ = bits.NewKeyHash(bits.PlainKeyHash, secret)
+	err = bits.Put(randBytesInput(bytes.NewReader(randb(1024)), secret), bitskeys.NewMemIterator(), conf)
+	if err != nil {
+		t.Fatalf("expected putting plain keys into a legacy store to succeed, got: %v", err)
+	}
+
+	if string(legacy.Meta[bits.KeyHashMetaName]) != string(bits.PlainKeyHash) {
+		t.Errorf("expected legacy store to record plain key hash, got: %q", legacy.Meta[bits.KeyHashMetaName])
+	}
+
+	mode, err = bits.StoreKeyHashMode(context.Background(), bitsstore.NewMemStore())
+	if err != nil || mode != "" {
+		t.Errorf("expected an empty store to have no key hash, got: %q (%v)", mode, err)
+	}
+}
@@ -0,0 +1,112 @@
+package bitskeys
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/advanderveer/libchunk/bits"
+)
+
+//BinaryKeyVersion is the version of the binary key format
+const BinaryKeyVersion = 1
+
+//BinaryKeyMagic starts each binary key list, it is followed by the version
+var BinaryKeyMagic = []byte("BITSKEYS")
+
+//BinaryKeyWriter writes a header followed by the raw bytes of each key,
+//it takes 32 bytes per key instead of the 45 of a line of text
+type BinaryKeyWriter struct {
+	w      io.Writer
+	header bool
+}
+
+//NewBinaryKeyWriter creates a key writer that writes to 'w'
+func NewBinaryKeyWriter(w io.Writer) *BinaryKeyWriter {
+	return &BinaryKeyWriter{w: w}
+}
+
+func (kw *BinaryKeyWriter) writeHeader() (err error) {
+	if kw.header {
+		return nil
+	}
+
+	kw.header = true
+	_, err = kw.w.Write(append(append([]byte{}, BinaryKeyMagic...), BinaryKeyVersion))
+	return err
+}
+
+//Write implements key writer
+func (kw *BinaryKeyWriter) Write(k bits.K) (err error) {
+	if err = kw.writeHeader(); err != nil {
+		return err
+	}
+
+	_, err = kw.w.Write(k[:])
+	return err
+}
+
+//Close writes the header if no keys were written, such that an empty list
+//can be read
+func (kw *BinaryKeyWriter) Close() error {
+	return kw.writeHeader()
+}
+
+//BinaryKeyReader reads keys that were written by the binary key writer
+type BinaryKeyReader struct {
+	br     *bufio.Reader
+	rw     *rewinder
+	header bool
+	err    error
+}
+
+//NewBinaryKeyReader creates a key reader that reads from 'r'
+func NewBinaryKeyReader(r io.Reader) *BinaryKeyReader {
+	return &BinaryKeyReader{br: bufio.NewReader(r), rw: newRewinder(r)}
+}
+
+//Reset seeks back to the first key, if the input cannot seek the next read
+//returns an error
+func (kr *BinaryKeyReader) Reset() {
+	kr.err = kr.rw.rewind()
+	kr.br.Reset(kr.rw.r)
+	kr.header = false
+}
+
+func (kr *BinaryKeyReader) readHeader() error {
+	if kr.header {
+		return nil
+	}
+
+	header := make([]byte, len(BinaryKeyMagic)+1)
+	_, err := io.ReadFull(kr.br, header)
+	if err != nil || !bytes.HasPrefix(header, BinaryKeyMagic) {
+		return fmt.Errorf("input doesn't start with the binary key header")
+	}
+
+	if v := header[len(BinaryKeyMagic)]; v != BinaryKeyVersion {
+		return fmt.Errorf("binary key list has version %d, only version %d is supported", v, BinaryKeyVersion)
+	}
+
+	kr.header = true
+	return nil
+}
+
+//Read implements key reader
+func (kr *BinaryKeyReader) Read() (k bits.K, err error) {
+	if kr.err != nil {
+		return k, kr.err
+	}
+
+	if err = kr.readHeader(); err != nil {
+		return k, err
+	}
+
+	_, err = io.ReadFull(kr.br, k[:])
+	if err == io.ErrUnexpectedEOF {
+		return k, fmt.Errorf("binary key list ends with a partial key")
+	}
+
+	return k, err
+}
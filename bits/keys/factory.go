@@ -1,7 +1,6 @@
 package bitskeys
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 
@@ -9,7 +8,7 @@ import (
 )
 
 //SupportedKeyFormats holds identifiers for all supported stores
-var SupportedKeyFormats = []string{"b64-textlines", "mem"}
+var SupportedKeyFormats = []string{"b64-textlines", "binary", "manifest", "mem"}
 
 //CreateKeyWriter attempts to create a specific writer
 func CreateKeyWriter(iotype string, w io.Writer) (kw bits.KeyWriter, err error) {
@@ -28,9 +27,13 @@ func CreateKeyWriter(iotype string, w io.Writer) (kw bits.KeyWriter, err error)
 	//maps factory args unto actual store creation
 	switch sname {
 	case "b64-textlines":
-		return &TextLineKeyWriter{w}, nil
+		return NewTextLineKeyWriter(w), nil
+	case "binary":
+		return NewBinaryKeyWriter(w), nil
+	case "manifest":
+		return NewManifestWriter(w), nil
 	case "mem":
-		return nil, fmt.Errorf("not implemented")
+		return NewMemKeyWriter(NewTextLineKeyWriter(w)), nil
 	default:
 		return nil, fmt.Errorf("type '%s' is not currently implemented", iotype)
 	}
@@ -53,9 +56,13 @@ func CreateKeyReader(iotype string, r io.Reader) (kr bits.KeyReader, err error)
 	//maps factory args unto actual store creation
 	switch sname {
 	case "b64-textlines":
-		return &TextLineKeyReader{bufio.NewScanner(r)}, nil
+		return NewTextLineKeyReader(r), nil
+	case "binary":
+		return NewBinaryKeyReader(r), nil
+	case "manifest":
+		return NewManifestReader(r)
 	case "mem":
-		return nil, fmt.Errorf("not implemented")
+		return ReadMemIterator(NewTextLineKeyReader(r))
 	default:
 		return nil, fmt.Errorf("type '%s' is not currently implemented", iotype)
 	}
@@ -0,0 +1,260 @@
+package bitskeys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/chunks"
+)
+
+//ManifestHeader is the first line of each manifest, it includes the version
+//of the format
+const ManifestHeader = "bits-manifest 1"
+
+//ManifestWriter writes a manifest of a file: each key on its own line with
+//the plaintext size of the chunk, followed by the total length and SHA-256
+//of the file. When keys are written without their chunk the manifest only
+//lists keys, as it cannot describe the file. It must be closed to write
+//the length and digest
+type ManifestWriter struct {
+	w      io.Writer
+	header bool
+	sized  bool
+	length int64
+	hash   hash.Hash
+
+	//how the file was cut into chunks, it is written in the header if set
+	//before the first key is written
+	Chunker *bitschunks.ChunkerConfig
+}
+
+//NewManifestWriter creates a manifest writer that writes to 'w'
+func NewManifestWriter(w io.Writer) *ManifestWriter {
+	return &ManifestWriter{w: w, sized: true, hash: sha256.New()}
+}
+
+func (kw *ManifestWriter) writeHeader() (err error) {
+	if kw.header {
+		return nil
+	}
+
+	kw.header = true
+	_, err = fmt.Fprintf(kw.w, "%s\n", ManifestHeader)
+	if err != nil || kw.Chunker == nil {
+		return err
+	}
+
+	data, err := json.Marshal(kw.Chunker)
+	if err != nil {
+		return fmt.Errorf("failed to encode chunker: %v", err)
+	}
+
+	_, err = fmt.Fprintf(kw.w, "chunker %s\n", data)
+	return err
+}
+
+//Write a key without knowing its chunk, the manifest will only list keys
+func (kw *ManifestWriter) Write(k bits.K) (err error) {
+	if err = kw.writeHeader(); err != nil {
+		return err
+	}
+
+	kw.sized = false
+	_, err = fmt.Fprintf(kw.w, "%s\n", k)
+	return err
+}
+
+//WriteChunk writes a key with the size of its plaintext chunk
+func (kw *ManifestWriter) WriteChunk(k bits.K, chunk []byte) (err error) {
+	if err = kw.writeHeader(); err != nil {
+		return err
+	}
+
+	kw.length += int64(len(chunk))
+	kw.hash.Write(chunk)
+	_, err = fmt.Fprintf(kw.w, "%s %d\n", k, len(chunk))
+	return err
+}
+
+//Close writes the length and digest of the file if all keys were written
+//with their chunk
+func (kw *ManifestWriter) Close() (err error) {
+	if err = kw.writeHeader(); err != nil || !kw.sized {
+		return err
+	}
+
+	_, err = fmt.Fprintf(kw.w, "length %d\nsha256 %x\n", kw.length, kw.hash.Sum(nil))
+	return err
+}
+
+//ManifestReader reads a manifest completely such that the length of the
+//file is known before its chunks are fetched. It verifies the sizes of
+//chunks and the digest of the file if the manifest describes them
+type ManifestReader struct {
+	i int
+
+	Chunker *bitschunks.ChunkerConfig
+	Keys    []bits.K
+	Sizes   []int64 //nil if the manifest only lists keys
+	Length  int64   //-1 if the manifest only lists keys
+	Digest  []byte  //nil if the manifest only lists keys
+
+	verified int
+	length   int64
+	hash     hash.Hash
+}
+
+//parseSize parses the length of the file or the size of a chunk, which
+//cannot be negative
+func parseSize(v string) (size int64, err error) {
+	size, err = strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if size < 0 {
+		return 0, fmt.Errorf("size %d is negative", size)
+	}
+
+	return size, nil
+}
+
+//NewManifestReader reads and parses a manifest from 'r'
+func NewManifestReader(r io.Reader) (kr *ManifestReader, err error) {
+	kr = &ManifestReader{Length: -1, hash: sha256.New()}
+	sc := bufio.NewScanner(r)
+	if !sc.Scan() || sc.Text() != ManifestHeader {
+		if sc.Err() != nil {
+			return nil, fmt.Errorf("failed to read manifest: %v", sc.Err())
+		}
+
+		return nil, fmt.Errorf("input doesn't start with manifest header '%s'", ManifestHeader)
+	}
+
+	sized := true
+	for n := 2; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "chunker" && len(fields) == 2:
+			kr.Chunker = &bitschunks.ChunkerConfig{}
+			err = json.Unmarshal([]byte(fields[1]), kr.Chunker)
+		case fields[0] == "length" && len(fields) == 2:
+			kr.Length, err = parseSize(fields[1])
+		case fields[0] == "sha256" && len(fields) == 2:
+			kr.Digest, err = hex.DecodeString(fields[1])
+		case len(fields) <= 2:
+			var k bits.K
+			if k, err = bits.DecodeKey([]byte(fields[0])); err != nil {
+				break
+			}
+
+			kr.Keys = append(kr.Keys, k)
+			if len(fields) == 1 {
+				sized = false
+				break
+			}
+
+			var size int64
+			size, err = parseSize(fields[1])
+			kr.Sizes = append(kr.Sizes, size)
+		default:
+			err = fmt.Errorf("unexpected number of fields")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse line %d of manifest: %v", n, err)
+		}
+	}
+
+	if sc.Err() != nil {
+		return nil, fmt.Errorf("failed to read manifest: %v", sc.Err())
+	}
+
+	if !sized {
+		kr.Sizes = nil
+	}
+
+	return kr, nil
+}
+
+//Offsets returns the offset in the file at which each chunk starts, or nil
+//if the manifest only lists keys
+func (kr *ManifestReader) Offsets() (offsets []int64) {
+	if kr.Sizes == nil {
+		return nil
+	}
+
+	offsets = make([]int64, len(kr.Sizes))
+	for i := 1; i < len(kr.Sizes); i++ {
+		offsets[i] = offsets[i-1] + kr.Sizes[i-1]
+	}
+
+	return offsets
+}
+
+//Reset the reader to the first key, verification starts anew
+func (kr *ManifestReader) Reset() {
+	kr.i = 0
+	kr.verified = 0
+	kr.length = 0
+	kr.hash.Reset()
+}
+
+//Read returns the next key or io.EOF if no more keys are available
+func (kr *ManifestReader) Read() (k bits.K, err error) {
+	if kr.i >= len(kr.Keys) {
+		return k, io.EOF
+	}
+
+	k = kr.Keys[kr.i]
+	kr.i++
+	return k, nil
+}
+
+//VerifyChunk checks that chunks arrive in the order of the manifest and
+//that they have the listed size
+func (kr *ManifestReader) VerifyChunk(k bits.K, chunk []byte) error {
+	if kr.verified >= len(kr.Keys) || kr.Keys[kr.verified] != k {
+		return fmt.Errorf("chunk '%s' is not the next chunk of the manifest", k)
+	}
+
+	if kr.Sizes != nil && kr.Sizes[kr.verified] != int64(len(chunk)) {
+		return fmt.Errorf("chunk '%s' has %d bytes while the manifest lists %d", k, len(chunk), kr.Sizes[kr.verified])
+	}
+
+	kr.verified++
+	kr.length += int64(len(chunk))
+	kr.hash.Write(chunk)
+	return nil
+}
+
+//Verify checks that all chunks were verified and that together they have
+//the length and digest of the manifest
+func (kr *ManifestReader) Verify() error {
+	if kr.verified != len(kr.Keys) {
+		return fmt.Errorf("only %d of the %d chunks of the manifest were verified", kr.verified, len(kr.Keys))
+	}
+
+	if kr.Length >= 0 && kr.length != kr.Length {
+		return fmt.Errorf("file has %d bytes while the manifest lists %d", kr.length, kr.Length)
+	}
+
+	if kr.Digest != nil && !bytes.Equal(kr.hash.Sum(nil), kr.Digest) {
+		return fmt.Errorf("file doesn't match the sha256 of the manifest: %x", kr.Digest)
+	}
+
+	return nil
+}
@@ -46,3 +46,42 @@ func (iter *MemIterator) Read() (k bits.K, err error) {
 	iter.i++
 	return k, nil
 }
+
+//ReadMemIterator reads all keys of 'kr' into memory, such that they can be
+//read again after a reset even if the input can only be read once
+func ReadMemIterator(kr bits.KeyReader) (iter *MemIterator, err error) {
+	iter = NewMemIterator()
+	for {
+		k, err := kr.Read()
+		if err == io.EOF {
+			return iter, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		iter.Keys = append(iter.Keys, k)
+	}
+}
+
+//MemKeyWriter keeps keys in memory and writes them to the key writer it
+//wraps when closed, e.g: such that nothing is written if the put fails
+type MemKeyWriter struct {
+	kw bits.KeyWriter
+	*MemIterator
+}
+
+//NewMemKeyWriter creates a key writer that writes to 'kw' when closed
+func NewMemKeyWriter(kw bits.KeyWriter) *MemKeyWriter {
+	return &MemKeyWriter{kw: kw, MemIterator: NewMemIterator()}
+}
+
+//Close writes all keys to the wrapped key writer
+func (kw *MemKeyWriter) Close() error {
+	for _, k := range kw.Keys {
+		if err := kw.kw.Write(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
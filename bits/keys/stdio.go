@@ -8,11 +8,48 @@ import (
 	"github.com/advanderveer/libchunk/bits"
 )
 
+//rewinder rewinds the input of a key reader to where reading started, this
+//is only possible if the input can seek, e.g: it is a regular file
+type rewinder struct {
+	r   io.Reader
+	off int64
+}
+
+func newRewinder(r io.Reader) *rewinder {
+	rw := &rewinder{r: r, off: -1}
+	if s, ok := r.(io.Seeker); ok {
+		off, err := s.Seek(0, io.SeekCurrent)
+		if err == nil {
+			rw.off = off
+		}
+	}
+
+	return rw
+}
+
+func (rw *rewinder) rewind() error {
+	if rw.off < 0 {
+		return fmt.Errorf("keys cannot be read again because the input cannot seek, e.g: it is a pipe")
+	}
+
+	_, err := rw.r.(io.Seeker).Seek(rw.off, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("failed to seek to the first key: %v", err)
+	}
+
+	return nil
+}
+
 //TextLineKeyWriter writes keys in encoded, each on a new line
 type TextLineKeyWriter struct {
 	w io.Writer
 }
 
+//NewTextLineKeyWriter creates a key writer that writes to 'w'
+func NewTextLineKeyWriter(w io.Writer) *TextLineKeyWriter {
+	return &TextLineKeyWriter{w}
+}
+
 //Write implementes key writer
 func (kw *TextLineKeyWriter) Write(k bits.K) (err error) {
 	_, err = fmt.Fprintf(kw.w, "%s\n", k)
@@ -21,20 +58,35 @@ func (kw *TextLineKeyWriter) Write(k bits.K) (err error) {
 
 //TextLineKeyReader writes keys in encoded, each on a new line
 type TextLineKeyReader struct {
-	sc *bufio.Scanner
+	sc  *bufio.Scanner
+	rw  *rewinder
+	err error
 }
 
-//Reset is not possible for this reader
-func (kr *TextLineKeyReader) Reset() {}
+//NewTextLineKeyReader creates a key reader that reads from 'r'
+func NewTextLineKeyReader(r io.Reader) *TextLineKeyReader {
+	return &TextLineKeyReader{sc: bufio.NewScanner(r), rw: newRewinder(r)}
+}
+
+//Reset seeks back to the first key, if the input cannot seek the next read
+//returns an error
+func (kr *TextLineKeyReader) Reset() {
+	kr.err = kr.rw.rewind()
+	kr.sc = bufio.NewScanner(kr.rw.r)
+}
 
 //Read implementes key reader
 func (kr *TextLineKeyReader) Read() (k bits.K, err error) {
-	if !kr.sc.Scan() {
-		return k, io.EOF
+	if kr.err != nil {
+		return k, kr.err
 	}
 
-	if kr.sc.Err() != nil {
-		return k, kr.sc.Err()
+	if !kr.sc.Scan() {
+		if kr.sc.Err() != nil {
+			return k, kr.sc.Err()
+		}
+
+		return k, io.EOF
 	}
 
 	return bits.DecodeKey(kr.sc.Bytes())
@@ -0,0 +1,137 @@
+package bits_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/chunks"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestManifest(t *testing.T) {
+	data := randb(64*1024 + 100)
+	conf := withStore(t, defaultConf(t, secret), bitsstore.NewMemStore())
+	cr, err := bitschunks.NewFixedChunker(bytes.NewReader(data), 4096)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+
+	manifest := bytes.NewBuffer(nil)
+	mw := bitskeys.NewManifestWriter(manifest)
+	mw.Chunker = &bitschunks.ChunkerConfig{Chunker: "fixed", ChunkerSettings: bitschunks.ChunkerSettings{BlockSize: 4096}}
+	err = bits.Put(cr, mw, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	err = mw.Close()
+	if err != nil {
+		t.Fatalf("failed to close manifest: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(manifest.String()), "\n")
+	if len(lines) != 1+1+17+2 || lines[0] != bitskeys.ManifestHeader || !strings.HasSuffix(lines[18], " 100") {
+		t.Fatalf("unexpected manifest:\n%s", manifest.String())
+	}
+
+	cases := []struct {
+		name        string
+		manifest    string
+		expectedErr string
+	}{{
+		"valid",
+		manifest.String(),
+		"",
+	}, {
+		"without_length_and_digest",
+		strings.Join(lines[:19], "\n"),
+		"",
+	}, {
+		"wrong_size",
+		strings.Replace(manifest.String(), " 100\n", " 101\n", 1),
+		"has 100 bytes while the manifest lists 101",
+	}, {
+		"wrong_length",
+		strings.Replace(manifest.String(), "length 65636", "length 65637", 1),
+		"file has 65636 bytes while the manifest lists 65637",
+	}, {
+		"wrong_digest",
+		strings.Join(lines[:20], "\n") + "\nsha256 " + strings.Repeat("00", 32),
+		"doesn't match the sha256 of the manifest",
+	}, {
+		"missing_chunk",
+		strings.Join(append(lines[:18:18], lines[19:]...), "\n"),
+		"file has 65536 bytes while the manifest lists 65636",
+	}}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			kr, err := bitskeys.NewManifestReader(strings.NewReader(c.manifest))
+			if err != nil {
+				t.Fatalf("failed to read manifest: %v", err)
+			}
+
+			if offsets := kr.Offsets(); len(offsets) != len(kr.Keys) || offsets[1] != 4096 {
+				t.Errorf("expected an offset for each chunk, got: %v", offsets)
+			}
+
+			if kr.Chunker == nil || kr.Chunker.Chunker != "fixed" {
+				t.Errorf("expected manifest to describe the chunker, got: %v", kr.Chunker)
+			}
+
+			output := bytes.NewBuffer(nil)
+			err = bits.Get(kr, output, conf)
+			if c.expectedErr == "" {
+				if err != nil {
+					t.Fatalf("failed to get: %v", err)
+				}
+
+				if !bytes.Equal(output.Bytes(), data) {
+					t.Errorf("expected output to equal input")
+				}
+			} else if err == nil || !strings.Contains(err.Error(), c.expectedErr) {
+				t.Errorf("expected error '%s', got: %v", c.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestManifestWithoutChunks(t *testing.T) {
+	k1 := bits.K{0x01}
+	manifest := bytes.NewBuffer(nil)
+	mw := bitskeys.NewManifestWriter(manifest)
+	if err := mw.Write(k1); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close manifest: %v", err)
+	}
+
+	if strings.Contains(manifest.String(), "length") {
+		t.Errorf("expected a manifest without length for keys without chunks, got:\n%s", manifest.String())
+	}
+
+	kr, err := bitskeys.NewManifestReader(manifest)
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+
+	if k, err := kr.Read(); err != nil || k != k1 || kr.Sizes != nil || kr.Length != -1 {
+		t.Errorf("expected key without size, got: %s, %v", k, err)
+	}
+
+	_, err = bitskeys.NewManifestReader(strings.NewReader("foo\n"))
+	if err == nil || !strings.Contains(err.Error(), "manifest header") {
+		t.Errorf("expected header error, got: %v", err)
+	}
+	for _, line := range []string{"length -1", k1.String() + " -5"} {
+		_, err = bitskeys.NewManifestReader(strings.NewReader(bitskeys.ManifestHeader + "\n" + line + "\n"))
+		if err == nil || !strings.Contains(err.Error(), "negative") {
+			t.Errorf("expected negative size in '%s' to fail parsing, got: %v", line, err)
+		}
+	}
+}
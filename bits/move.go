@@ -1,119 +1,282 @@
 package bits
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"time"
 )
 
+//MoveStats describes what happened to the keys that were read during a move
+type MoveStats struct {
+	Total     int64 //number of keys that were read
+	Moved     int64 //number of keys that were moved and written to the key writer
+	Journaled int64 //number of keys skipped because the journal showed they were moved earlier
+	Indexed   int64 //number of keys skipped because the index showed, or the remote confirmed, they are already present
+}
+
 //Move will attempt to move all keys read from key reader 'kr' to the
 //(remote)store configured in Config 'conf' and outputs pushed keys
-//to key writer 'kw'.
+//to key writer 'kw'. If a journal is configured, keys that are moved
+//are recorded in it and keys that it already holds are skipped. Keys
+//that a remote store holds are skipped too, it is indexed first.
 func Move(kr KeyReader, kw KeyWriter, conf Config) error {
+	_, err := MoveContext(context.Background(), kr, kw, conf)
+	return err
+}
+
+//MoveContext is like Move but stops reading keys and aborts all work when
+//the context is cancelled or its deadline exceeds. It only returns when
+//all concurrent work has stopped. Stats are returned even when the move
+//failed, such that progress can be reported
+func MoveContext(ctx context.Context, kr KeyReader, kw KeyWriter, conf Config) (stats MoveStats, err error) {
 
 	//result of working the item
 	type result struct {
-		err error
+		indexed bool
+		err     error
 	}
 
 	//work item
 	type item struct {
-		key   K
-		resCh chan *result
-		err   error
+		key       K
+		resCh     chan *result
+		journaled bool
+		indexed   bool
+		confirm   bool
+		err       error
 	}
 
 	//setup stores based on configuration
 	src, err := conf.Stores.MoveSrc()
 	if err != nil {
-		return fmt.Errorf("couldnt get store to move from: %v", err)
+		return stats, fmt.Errorf("couldnt get store to move from: %v", err)
 	}
 
 	dst, err := conf.Stores.MoveDst()
 	if err != nil {
-		return fmt.Errorf("couldnt get store to move to: %v", err)
+		return stats, fmt.Errorf("couldnt get store to move to: %v", err)
+	}
+
+	//keys are not hashed again, the destination takes on the key hash
+	//mode of the source if it recorded one
+	mode, err := StoreKeyHashMode(ctx, src)
+	if err != nil {
+		return stats, err
+	}
+
+	err = checkKeyHashMode(ctx, dst, mode)
+	if err != nil {
+		return stats, err
+	}
+
+	//keys that are moved are written to an index that persists, such
+	//that it stays fresh. Buffered keys are flushed when returning
+	sidx, _ := conf.Index.(SyncedIndex)
+	if conf.NoIndex {
+		sidx = nil
+	}
+
+	if sidx != nil {
+		defer func() {
+			if ferr := sidx.Flush(); ferr != nil && err == nil {
+				err = fmt.Errorf("failed to flush index: %v", ferr)
+			}
+		}()
 	}
 
+	//when returning early, cancel and wait for in-flight work to stop
+	wg := &workGroup{}
+	defer wg.StopAndWait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	//concurrent work
+	hdst, _ := dst.(HasStore)
 	work := func(it *item) {
-		chunk, err := src.Get(it.key)
+		if it.confirm && hdst != nil {
+			ok, err := hasContext(ctx, hdst, it.key)
+			if err == nil && ok {
+				it.resCh <- &result{indexed: true}
+				return
+			}
+		}
+
+		chunk, err := getContext(ctx, src, it.key)
 		if err != nil {
-			it.resCh <- &result{fmt.Errorf("failed to get chunk '%s' from store: %v", it.key, err)}
+			it.resCh <- &result{err: fmt.Errorf("failed to get chunk '%s' from store: %w", it.key, err)}
 			return
 		}
 
-		err = dst.Put(it.key, chunk)
+		err = putContext(ctx, dst, it.key, chunk)
 		if err != nil {
-			it.resCh <- &result{fmt.Errorf("failed to put chunk '%s' to remote: %v", it.key, err)}
+			it.resCh <- &result{err: fmt.Errorf("failed to put chunk '%s' to remote: %w", it.key, err)}
 			return
 		}
 
+		if sidx != nil {
+			err = sidx.Write(it.key)
+			if err != nil {
+				it.resCh <- &result{err: fmt.Errorf("failed to record chunk '%s' in index: %v", it.key, err)}
+				return
+			}
+		}
+
+		if conf.Journal != nil {
+			err = conf.Journal.Write(it.key)
+			if err != nil {
+				it.resCh <- &result{err: fmt.Errorf("failed to record chunk '%s' in journal: %v", it.key, err)}
+				return
+			}
+		}
+
 		it.resCh <- &result{}
 	}
 
-	//if the dst is an remote store and an index is configured
-	//fill the index first so we can prevent unnessary work
+	//if the dst is an remote store fill the index first so we can
+	//prevent unnessary work, an index is created in memory if none is
+	//configured. An index that persists between moves is only filled
+	//if stale
 	var idx KeyIndex
-	if remote, ok := dst.(RemoteStore); ok {
-		if conf.Index != nil {
-			idx = conf.Index
-			err := remote.Index(idx)
+	if remote, ok := dst.(RemoteStore); ok && !conf.NoIndex {
+		idx = conf.Index
+		if idx == nil {
+			idx = memIndex{}
+		}
+
+		//the epoch is read before listing, such that garbage that is
+		//collected while listing makes the index stale again
+		var epoch []byte
+		if sidx != nil {
+			epoch, err = gcEpoch(ctx, dst)
+			if err != nil {
+				return stats, err
+			}
+		}
+
+		if sidx == nil || !sidx.Fresh(epoch) {
+			started := time.Now()
+			if sidx != nil {
+				err := sidx.StartSync()
+				if err != nil {
+					return stats, fmt.Errorf("failed to start sync of index: %v", err)
+				}
+			}
+
+			err := indexContext(ctx, remote, idx)
 			if err != nil {
-				return fmt.Errorf("failed to index remote: %v", err)
+				return stats, fmt.Errorf("failed to index remote: %w", err)
+			}
+
+			if sidx != nil {
+				err = sidx.MarkSynced(started, epoch)
+				if err != nil {
+					return stats, fmt.Errorf("failed to mark index as synced: %v", err)
+				}
 			}
 		}
-		//@TODO always create memory index if the store is indexable
+	}
+
+	confirm := false
+	if pidx, ok := idx.(ProbabilisticIndex); ok {
+		confirm = pidx.FalsePositiveRate() > 0
 	}
 
 	//fan-out
 	itemCh := make(chan *item, conf.MoveConcurrency)
 	go func() {
 		defer close(itemCh)
-		for {
+		for ctx.Err() == nil {
 			k, err := kr.Read()
 			if err != nil {
 				if err != io.EOF {
-					itemCh <- &item{
-						key: k,
-						err: fmt.Errorf("failed to iterate into next key: %v", err),
+					select {
+					case itemCh <- &item{key: k, err: fmt.Errorf("failed to iterate into next key: %v", err)}:
+					case <-ctx.Done():
 					}
 				}
 
 				break
 			}
 
-			//we may be able to skip work altogether if an index
-			//is present and it contains the key we intent to work on
-			if idx != nil && idx.Has(k) {
-				continue
+			//we may be able to skip work altogether if the journal shows
+			//the key was moved before or if an index is present and it
+			//contains the key we intent to work on
+			it := &item{key: k}
+			if conf.Journal != nil && conf.Journal.Has(k) {
+				it.journaled = true
+			} else if idx != nil && idx.Has(k) {
+				it.indexed = true
+			}
+
+			//hits of an index that has false positives are confirmed
+			//by the work, it moves the chunk if the remote lacks it
+			if it.indexed && confirm {
+				it.indexed, it.confirm = false, true
 			}
 
-			it := &item{
-				key:   k,
-				resCh: make(chan *result),
+			if !it.journaled && !it.indexed {
+				it.resCh = make(chan *result, 1)
+				if !wg.Go(func() { work(it) }) { //create work
+					return
+				}
 			}
 
-			go work(it)  //create work
-			itemCh <- it //send to fan-in thread for syncing results
+			select {
+			case itemCh <- it: //send to fan-in thread for syncing results
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
 	//fan-in
 	for it := range itemCh {
 		if it.err != nil {
-			return fmt.Errorf("failed to iterate: %v", it.err)
+			return stats, fmt.Errorf("failed to iterate: %v", it.err)
+		}
+
+		stats.Total++
+		if it.journaled {
+			stats.Journaled++
+			continue
+		}
+
+		if it.indexed {
+			stats.Indexed++
+			continue
 		}
 
 		res := <-it.resCh
 		if res.err != nil {
-			return res.err
+			return stats, res.err
+		}
+
+		if res.indexed {
+			stats.Indexed++
+			continue
 		}
 
 		err := kw.Write(it.key)
 		if err != nil {
-			return fmt.Errorf("handler failed for key '%s': %v", it.key, err)
+			return stats, fmt.Errorf("handler failed for key '%s': %v", it.key, err)
 		}
 
+		stats.Moved++
 	}
 
+	return stats, ctx.Err()
+}
+
+//memIndex is the index that Move fills when none is configured
+type memIndex map[K]struct{}
+
+func (idx memIndex) Write(k K) error {
+	idx[k] = struct{}{}
 	return nil
 }
+
+func (idx memIndex) Has(k K) bool {
+	_, ok := idx[k]
+	return ok
+}
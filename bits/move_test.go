@@ -2,12 +2,19 @@ package bits_test
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/advanderveer/libchunk/bits"
 	"github.com/advanderveer/libchunk/bits/index"
 	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
 )
 
 func TestMove(t *testing.T) {
@@ -80,3 +87,335 @@ func TestMove(t *testing.T) {
 		})
 	}
 }
+
+//flakyStore fails every put after the first 'n' puts
+type flakyStore struct {
+	*bitsstore.MemStore
+	mu sync.Mutex
+	n  int
+}
+
+func (store *flakyStore) Put(k bits.K, c []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.n < 1 {
+		return fmt.Errorf("storage_failed")
+	}
+
+	store.n--
+	return store.MemStore.Put(k, c)
+}
+
+func TestMoveResume(t *testing.T) {
+	conf := withTmpBoltStore(t, defaultConf(t, secret))
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewBuffer(randb(12*1024*1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to split first: %v", err)
+	}
+
+	src, _ := conf.Stores.PutDst()
+	journal, err := bitsstore.NewBoltJournal(src.(*bitsstore.BoltStore).DB, "test-transfer")
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+
+	remote := bitsstore.NewMemStore()
+	conf = withRemote(t, conf, &flakyStore{MemStore: remote, n: 1})
+	conf.Journal = journal
+	conf.MoveConcurrency = 1
+
+	stats, err := bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err == nil || !strings.Contains(err.Error(), "storage_failed") {
+		t.Fatalf("expected first move to fail, got: %v", err)
+	}
+
+	n, err := journal.Count()
+	if err != nil || n != len(remote.Chunks) || n < 1 {
+		t.Fatalf("expected journal to hold the %d moved keys, got: %d (%v)", len(remote.Chunks), n, err)
+	}
+
+	keys.Reset()
+	conf = withRemote(t, conf, remote)
+	moved := bitskeys.NewMemIterator()
+	stats, err = bits.MoveContext(context.Background(), keys, moved, conf)
+	if err != nil {
+		t.Fatalf("expected resumed move to succeed, got: %v", err)
+	}
+
+	if stats.Journaled != int64(n) || stats.Moved != stats.Total-int64(n) || len(moved.Keys) != int(stats.Moved) {
+		t.Errorf("expected journaled keys to be skipped, got: %+v", stats)
+	}
+
+	if len(remote.Chunks) != len(keys.Keys) {
+		t.Errorf("expected all %d chunks to be moved, got: %d", len(keys.Keys), len(remote.Chunks))
+	}
+}
+
+//listingRemote is a remote store that counts how often it is indexed
+type listingRemote struct {
+	*bitsstore.MemStore
+	listed int
+}
+
+func (r *listingRemote) Index(kw bits.KeyWriter) error {
+	r.listed++
+	for k := range r.Chunks {
+		if err := kw.Write(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestMoveWithBoltIndex(t *testing.T) {
+	conf := withTmpBoltStore(t, defaultConf(t, secret))
+	remote := &listingRemote{MemStore: bitsstore.NewMemStore()}
+	conf = withRemote(t, conf, remote)
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewBuffer(randb(4*1024*1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	idx, err := bitsindex.NewBoltIndex(conf.Stores["local"].(*bitsstore.BoltStore).DB, "remote", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open index: %v", err)
+	}
+
+	idx.BatchSize = 2
+	conf.Index = idx
+	stats, err := bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || stats.Moved != int64(len(keys.Keys)) || remote.listed != 1 {
+		t.Fatalf("expected all keys to be moved after listing the remote once, got: %+v, %d listings (%v)", stats, remote.listed, err)
+	}
+
+	if n, err := idx.Count(); err != nil || n != len(keys.Keys) {
+		t.Fatalf("expected moved keys to be indexed, got %d (%v)", n, err)
+	}
+
+	//the index is fresh, the remote isn't listed again
+	keys.Reset()
+	stats, err = bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || stats.Indexed != int64(len(keys.Keys)) || remote.listed != 1 {
+		t.Fatalf("expected all keys to be skipped without listing, got: %+v, %d listings (%v)", stats, remote.listed, err)
+	}
+
+	//a stale index is rebuilt by listing the remote again, a chunk that was
+	//deleted from the remote out of band is moved again
+	remote.Lock()
+	delete(remote.Chunks, keys.Keys[0])
+	remote.Unlock()
+
+	idx.MaxAge = 0
+	keys.Reset()
+	stats, err = bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || remote.listed != 2 || stats.Moved != 1 {
+		t.Fatalf("expected stale index to be rebuilt, got: %+v, %d listings (%v)", stats, remote.listed, err)
+	}
+
+	if n, err := idx.Count(); err != nil || n != len(keys.Keys) {
+		t.Fatalf("expected rebuilt index to hold all keys, got %d (%v)", n, err)
+	}
+
+	//collecting garbage from the remote makes a fresh index stale, chunks
+	//it deleted are moved again
+	idx.MaxAge = time.Hour
+	gconf := withStore(t, defaultConf(t, secret), remote)
+	gconf.GCGracePeriod = 0
+	gstats, err := bits.GC(bitskeys.NewPopulatedMemIterator(keys.Keys[1:]), gconf)
+	if err != nil || gstats.Deleted != 1 || idx.Fresh(remote.Meta[bits.GCEpochMetaName]) {
+		t.Fatalf("expected gc to delete a chunk and make the index stale, got: %+v (%v)", gstats, err)
+	}
+
+	keys.Reset()
+	stats, err = bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || remote.listed != 3 || stats.Moved != 1 {
+		t.Fatalf("expected index to be synced after gc, got: %+v, %d listings (%v)", stats, remote.listed, err)
+	}
+
+	if !idx.Fresh(remote.Meta[bits.GCEpochMetaName]) {
+		t.Fatalf("expected index to be fresh at the gc epoch of the remote")
+	}
+
+	//a sync that doesn't list any keys leaves an empty index
+	err = idx.StartSync()
+	if err == nil {
+		err = idx.MarkSynced(time.Now(), nil)
+	}
+
+	if n, _ := idx.Count(); err != nil || n != 0 || idx.Has(keys.Keys[0]) {
+		t.Fatalf("expected keys that weren't listed to be dropped, got %d keys (%v)", n, err)
+	}
+
+	synced, err := idx.Synced()
+	if err != nil || time.Since(synced) > time.Minute {
+		t.Errorf("expected a recent sync time, got: %v (%v)", synced, err)
+	}
+
+	err = idx.Clear()
+	if n, _ := idx.Count(); err != nil || n != 0 || idx.Fresh(nil) {
+		t.Errorf("expected index to be cleared, got %d keys (%v)", n, err)
+	}
+}
+
+func TestMoveWithBloomIndex(t *testing.T) {
+	chunks := map[bits.K][]byte{}
+	conf := withTmpBoltStore(t, withS3Remote(t, defaultConf(t, secret), chunks))
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewBuffer(randb(4*1024*1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	//an index that claims to hold every key, the remote holds none of them
+	idx, err := bitsindex.NewBloomIndex(1000, 0.01)
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+
+	for _, k := range keys.Keys {
+		idx.Write(k)
+	}
+
+	conf.Index = idx
+	stats, err := bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || stats.Moved != int64(len(keys.Keys)) || stats.Indexed != 0 {
+		t.Fatalf("expected unconfirmed index hits to be moved, got: %+v (%v)", stats, err)
+	}
+
+	keys.Reset()
+	stats, err = bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || stats.Indexed != int64(len(keys.Keys)) {
+		t.Fatalf("expected confirmed index hits to be skipped, got: %+v (%v)", stats, err)
+	}
+}
+
+func TestMoveIndexesRemoteByDefault(t *testing.T) {
+	conf := withTmpBoltStore(t, defaultConf(t, secret))
+	remote := &listingRemote{MemStore: bitsstore.NewMemStore()}
+	conf = withRemote(t, conf, remote)
+	keys := bitskeys.NewMemIterator()
+	err := bits.Put(randBytesInput(bytes.NewBuffer(randb(4*1024*1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	err = bits.Move(keys, bitskeys.NewMemIterator(), conf)
+	if err != nil {
+		t.Fatalf("failed to move: %v", err)
+	}
+
+	keys.Reset()
+	stats, err := bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || stats.Indexed != int64(len(keys.Keys)) || remote.listed != 2 {
+		t.Fatalf("expected all keys to be skipped after listing the remote, got: %+v, %d listings (%v)", stats, remote.listed, err)
+	}
+
+	keys.Reset()
+	conf.NoIndex = true
+	stats, err = bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || stats.Moved != int64(len(keys.Keys)) || remote.listed != 2 {
+		t.Fatalf("expected all keys to be moved without listing, got: %+v, %d listings (%v)", stats, remote.listed, err)
+	}
+}
+
+func TestMoveToPackRemote(t *testing.T) {
+	mem := bitsstore.NewMemStore()
+	svr := httptest.NewServer(mem)
+	defer svr.Close()
+
+	pidx := withTmpBoltStore(t, defaultConf(t, secret)).Stores["local"].(*bitsstore.BoltStore)
+	aead, err := bitsstore.NewPackAEAD("my-secret")
+	if err != nil {
+		t.Fatalf("failed to create pack cipher: %v", err)
+	}
+
+	remote, err := bitsstore.NewPackRemote(bitsstore.NewS3Remote("http", strings.TrimPrefix(svr.URL, "http://"), "", "", ""), pidx.DB, aead, 16*1024*1024)
+	if err != nil {
+		t.Fatalf("failed to create pack remote: %v", err)
+	}
+
+	conf := withRemote(t, withTmpBoltStore(t, defaultConf(t, secret)), remote)
+	data := randb(4 * 1024 * 1024)
+	keys := bitskeys.NewMemIterator()
+	err = bits.Put(randBytesInput(bytes.NewBuffer(data), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	stats, err := bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+	if err != nil || stats.Moved != int64(len(keys.Keys)) {
+		t.Fatalf("expected all keys to be moved, got: %+v (%v)", stats, err)
+	}
+
+	if len(mem.Chunks) != 0 || len(mem.Objects) < 1 || (len(keys.Keys) > 1 && len(mem.Objects) >= len(keys.Keys)) {
+		t.Errorf("expected %d chunks to be moved in fewer packs, got %d chunks and %d packs", len(keys.Keys), len(mem.Chunks), len(mem.Objects))
+	}
+
+	keys.Reset()
+	output := bytes.NewBuffer(nil)
+	err = bits.Get(keys, output, withRemote(t, defaultConf(t, secret), remote))
+	if err != nil || !bytes.Equal(output.Bytes(), data) {
+		t.Errorf("expected output to equal input from packs (%v)", err)
+	}
+}
+
+func TestMoveTransientFailure(t *testing.T) {
+	//only listing the bucket fails, the key hash of the store is recorded
+	mem := bitsstore.NewMemStore()
+	err := mem.PutMeta(bits.KeyHashMetaName, []byte(bits.KeyedKeyHash))
+	if err != nil {
+		t.Fatalf("failed to record key hash: %v", err)
+	}
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("list-type") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		mem.ServeHTTP(w, r)
+	}))
+
+	defer svr.Close()
+	s3 := bitsstore.NewS3Remote("http", strings.TrimPrefix(svr.URL, "http://"), "", "", "")
+	s3.MaxRetries = 2
+	s3.RetryBaseDelay = time.Millisecond
+
+	aead, err := bitsstore.NewPackAEAD("my-secret")
+	if err != nil {
+		t.Fatalf("failed to create pack cipher: %v", err)
+	}
+
+	pidx := withTmpBoltStore(t, defaultConf(t, secret)).Stores["local"].(*bitsstore.BoltStore)
+	pack, err := bitsstore.NewPackRemote(s3, pidx.DB, aead, 16*1024*1024)
+	if err != nil {
+		t.Fatalf("failed to create pack remote: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		remote bits.Store
+	}{
+		{"s3", s3},
+		{"pack", pack},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := withRemote(t, withTmpBoltStore(t, defaultConf(t, secret)), c.remote)
+			keys := bitskeys.NewMemIterator()
+			err := bits.Put(randBytesInput(bytes.NewBuffer(randb(1024*1024)), secret), keys, conf)
+			if err != nil {
+				t.Fatalf("failed to put: %v", err)
+			}
+
+			_, err = bits.MoveContext(context.Background(), keys, bitskeys.NewMemIterator(), conf)
+			if err == nil || !strings.Contains(err.Error(), "failed to index remote") || !bits.IsTransient(err) {
+				t.Errorf("expected a transient error when indexing the remote, got: %v", err)
+			}
+		})
+	}
+}
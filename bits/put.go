@@ -1,9 +1,10 @@
 package bits
 
 import (
-	"crypto/rand"
+	"context"
 	"fmt"
 	"io"
+	"sync"
 )
 
 //Put reads chunks from a chunk reader and stores each chunk encrypted under a
@@ -11,6 +12,13 @@ import (
 //are run concurrently but keys are guaranteed to arrive at 'kw' in order,
 //i.e: key of the first chunk will be writtern first
 func Put(cr ChunkReader, kw KeyWriter, conf Config) error {
+	return PutContext(context.Background(), cr, kw, conf)
+}
+
+//PutContext is like Put but stops reading chunks and aborts all work when
+//the context is cancelled or its deadline exceeds. It only returns when
+//all concurrent work has stopped.
+func PutContext(ctx context.Context, cr ChunkReader, kw KeyWriter, conf Config) error {
 
 	//result of working an item
 	type result struct {
@@ -32,32 +40,61 @@ func Put(cr ChunkReader, kw KeyWriter, conf Config) error {
 		return fmt.Errorf("failed to get a store to put chunks in: %v", err)
 	}
 
-	//concurrent work
+	//keys that are hashed differently must not end up in the same store
+	err = checkKeyHashMode(ctx, dst, conf.KeyHashMode)
+	if err != nil {
+		return err
+	}
+
+	//when returning early, cancel and wait for in-flight work to stop
+	wg := &workGroup{}
+	defer wg.StopAndWait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	//concurrent work, all-zero chunks (e.g: sparse regions of disk images)
+	//are only hashed, encrypted and stored once for each size
+	zeros := &zeroKeys{keys: map[int]K{}}
 	work := func(it *item) {
 		res := &result{}
-		res.key = conf.KeyHash(it.chunk) //Hash
-		nonce := make([]byte, conf.AEAD.NonceSize())
-		if _, res.err = rand.Read(nonce); res.err != nil {
+		zero := isZero(it.chunk)
+		if zero {
+			if k, ok := zeros.get(len(it.chunk)); ok {
+				res.key = k
+				it.resCh <- res
+				return
+			}
+		}
+
+		res.key = conf.KeyHash(it.chunk)                  //Hash
+		sealed, err := sealChunk(conf, res.key, it.chunk) //Encrypt
+		if err != nil {
+			res.err = err
 			it.resCh <- res
 			return
 		}
 
-		encrypted := conf.AEAD.Seal(nil, nonce, it.chunk, nil)  //Encrypt
-		res.err = dst.Put(res.key, append(nonce, encrypted...)) //Store
-		it.resCh <- res                                         //Output
+		res.err = putContext(ctx, dst, res.key, sealed) //Store
+		if zero && res.err == nil {
+			zeros.set(len(it.chunk), res.key)
+		}
+
+		it.resCh <- res //Output
 	}
 
-	//fan out, closes channels when unable to perform more work
+	//fan out, closes channels when unable to perform more work. The reader
+	//cannot be interrupted so at most one read finishes after cancellation
 	itemCh := make(chan *item, conf.PutConcurrency)
 	go func() {
 		defer close(itemCh)
 		pos := int64(0)
-		for {
+		for ctx.Err() == nil {
 			chunk, err := cr.Read()
 			if err != nil {
 				if err != io.EOF {
-					itemCh <- &item{
-						err: err,
+					select {
+					case itemCh <- &item{err: err}:
+					case <-ctx.Done():
 					}
 				}
 
@@ -67,11 +104,19 @@ func Put(cr ChunkReader, kw KeyWriter, conf Config) error {
 			it := &item{
 				pos:   pos,
 				chunk: chunk,
-				resCh: make(chan *result),
+				resCh: make(chan *result, 1),
+			}
+
+			if !wg.Go(func() { work(it) }) { //create work
+				return
+			}
+
+			select {
+			case itemCh <- it: //send to fan-in for syncing results
+			case <-ctx.Done():
+				return
 			}
 
-			go work(it)  //create work
-			itemCh <- it //send to fan-in for syncing results
 			pos++
 		}
 	}()
@@ -97,7 +142,12 @@ func Put(cr ChunkReader, kw KeyWriter, conf Config) error {
 			return fmt.Errorf("work failed on chunk '%s': %v", res.key, res.err)
 		}
 
-		err := kw.Write(res.key)
+		if ckw, ok := kw.(ChunkKeyWriter); ok {
+			err = ckw.WriteChunk(res.key, it.chunk)
+		} else {
+			err = kw.Write(res.key)
+		}
+
 		if err != nil {
 			return fmt.Errorf("chunk handle for '%s' failed: %v", res.key, err)
 		}
@@ -105,5 +155,35 @@ func Put(cr ChunkReader, kw KeyWriter, conf Config) error {
 		lastpos = it.pos
 	}
 
-	return nil
+	return ctx.Err()
+}
+
+//zeroKeys remembers the keys of all-zero chunks that were stored, by size
+type zeroKeys struct {
+	mu   sync.Mutex
+	keys map[int]K
+}
+
+func (z *zeroKeys) get(size int) (k K, ok bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	k, ok = z.keys[size]
+	return k, ok
+}
+
+func (z *zeroKeys) set(size int, k K) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.keys[size] = k
+}
+
+//isZero returns whether all bytes of 'b' are zero
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+
+	return true
 }
@@ -6,7 +6,9 @@ import (
 	"testing"
 
 	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/chunks"
 	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
 )
 
 //TestPut tests splitting of data streams
@@ -86,3 +88,34 @@ func TestPut(t *testing.T) {
 		})
 	}
 }
+
+func TestPutZeroChunks(t *testing.T) {
+	input := append(make([]byte, 64*4096), randb(4096)...)
+	cr, err := bitschunks.NewFixedChunker(bytes.NewReader(input), 4096)
+	if err != nil {
+		t.Fatalf("failed to create chunker: %v", err)
+	}
+
+	store := &countingStore{Store: bitsstore.NewMemStore()}
+	conf := withStore(t, defaultConf(t, secret), store)
+	conf.PutConcurrency = 1
+	keys := bitskeys.NewMemIterator()
+	err = bits.Put(cr, keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	if len(keys.Keys) != 65 || keys.Keys[0] != keys.Keys[63] || keys.Keys[0] == keys.Keys[64] {
+		t.Fatalf("expected 64 identical zero block keys and one other key, got %d keys", len(keys.Keys))
+	}
+
+	if store.puts > 1+1+conf.PutConcurrency {
+		t.Errorf("expected zero blocks to be stored once, got %d puts", store.puts)
+	}
+
+	output := bytes.NewBuffer(nil)
+	err = bits.Get(keys, output, conf)
+	if err != nil || !bytes.Equal(output.Bytes(), input) {
+		t.Errorf("expected output to equal input (%v)", err)
+	}
+}
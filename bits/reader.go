@@ -0,0 +1,201 @@
+package bits
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+//Reader provides random access to the data of a list of keys. It only
+//fetches and decrypts the chunks that cover the requested range and keeps
+//recently used chunks in memory. It implements io.ReadSeeker and io.ReaderAt
+type Reader struct {
+	conf    Config
+	srcs    []Store
+	keys    []K
+	offsets []int64
+	pos     int64
+
+	mu    sync.Mutex
+	cache *chunkCache
+}
+
+//NewReader creates a reader over the plaintext chunks of 'keys', the size of
+//each plaintext chunk must be provided in 'chunkSizes' such that the chunks
+//that cover a requested range can be determined without fetching them
+func NewReader(keys []K, chunkSizes []int64, conf Config) (r *Reader, err error) {
+	if len(keys) != len(chunkSizes) {
+		return nil, fmt.Errorf("expected a chunk size for each of the %d keys, got %d sizes", len(keys), len(chunkSizes))
+	}
+
+	r = &Reader{
+		conf:    conf,
+		srcs:    conf.Stores.GetSrcs(conf.GetOrder...),
+		keys:    keys,
+		offsets: make([]int64, len(keys)+1),
+		cache:   newChunkCache(conf.ReadCacheSize),
+	}
+
+	for i, size := range chunkSizes {
+		if size < 0 {
+			return nil, fmt.Errorf("chunk size of key '%s' is negative", keys[i])
+		}
+
+		r.offsets[i+1] = r.offsets[i] + size
+	}
+
+	return r, nil
+}
+
+//Size returns the total size of the plaintext data
+func (r *Reader) Size() int64 {
+	return r.offsets[len(r.keys)]
+}
+
+//chunk returns plaintext chunk 'i' from the cache or fetches it
+func (r *Reader) chunk(i int) (chunk []byte, err error) {
+	k := r.keys[i]
+	r.mu.Lock()
+	chunk, ok := r.cache.Get(k)
+	r.mu.Unlock()
+	if ok {
+		return chunk, nil
+	}
+
+	sealed, err := fetchChunk(context.Background(), r.srcs, k, r.conf.HedgeDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err = openChunk(r.conf, k, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open chunk '%s': %v", k, err)
+	}
+
+	if int64(len(chunk)) != r.offsets[i+1]-r.offsets[i] {
+		return nil, fmt.Errorf("chunk '%s' is %d bytes while %d bytes were expected", k, len(chunk), r.offsets[i+1]-r.offsets[i])
+	}
+
+	r.mu.Lock()
+	r.cache.Add(k, chunk)
+	r.mu.Unlock()
+	return chunk, nil
+}
+
+//ReadAt reads len(p) bytes starting at offset 'off', it is safe to be called
+//concurrently. It returns io.EOF if fewer bytes were read because the end of
+//the data was reached
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("bits.Reader.ReadAt: negative offset")
+	}
+
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.Size() {
+			return n, io.EOF
+		}
+
+		//find the chunk that holds the position
+		i := sort.Search(len(r.keys), func(i int) bool { return r.offsets[i+1] > pos })
+		chunk, err := r.chunk(i)
+		if err != nil {
+			return n, err
+		}
+
+		n += copy(p[n:], chunk[pos-r.offsets[i]:])
+	}
+
+	return n, nil
+}
+
+//Read reads up to len(p) bytes from the current offset
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.pos >= r.Size() {
+		return 0, io.EOF
+	}
+
+	n, err = r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+//Seek sets the offset for the next Read
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, errors.New("bits.Reader.Seek: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("bits.Reader.Seek: negative position")
+	}
+
+	r.pos = offset
+	return offset, nil
+}
+
+//chunkCache keeps the most recently used plaintext chunks in memory, it is
+//not safe for concurrent use
+type chunkCache struct {
+	size    int
+	order   *list.List
+	entries map[K]*list.Element
+}
+
+type cacheEntry struct {
+	k     K
+	chunk []byte
+}
+
+func newChunkCache(size int) *chunkCache {
+	return &chunkCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[K]*list.Element{},
+	}
+}
+
+//Get a chunk from the cache and mark it as most recently used
+func (c *chunkCache) Get(k K) (chunk []byte, ok bool) {
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).chunk, true
+}
+
+//Add a chunk to the cache and evict the least recently used chunk if
+//the cache is full
+func (c *chunkCache) Add(k K, chunk []byte) {
+	if c.size < 1 {
+		return
+	}
+
+	if e, ok := c.entries[k]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[k] = c.order.PushFront(&cacheEntry{k, chunk})
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*cacheEntry).k)
+	}
+}
@@ -0,0 +1,163 @@
+package bits_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+//sizeRecorder records the size of each chunk that is read
+type sizeRecorder struct {
+	bits.ChunkReader
+	sizes []int64
+}
+
+func (r *sizeRecorder) Read() (c []byte, err error) {
+	c, err = r.ChunkReader.Read()
+	if err == nil {
+		r.sizes = append(r.sizes, int64(len(c)))
+	}
+
+	return c, err
+}
+
+//countingStore counts the number of gets and puts
+type countingStore struct {
+	bits.Store
+	mu   sync.Mutex
+	gets int
+	puts int
+}
+
+func (store *countingStore) Put(k bits.K, c []byte) error {
+	store.mu.Lock()
+	store.puts++
+	store.mu.Unlock()
+	return store.Store.Put(k, c)
+}
+
+func (store *countingStore) Get(k bits.K) (c []byte, err error) {
+	store.mu.Lock()
+	store.gets++
+	store.mu.Unlock()
+	return store.Store.Get(k)
+}
+
+func TestReader(t *testing.T) {
+	data := randb(20 * 1024 * 1024)
+	store := &countingStore{Store: bitsstore.NewMemStore()}
+	conf := withStore(t, defaultConf(t, secret), store)
+	keys := bitskeys.NewMemIterator()
+	input := &sizeRecorder{ChunkReader: randBytesInput(bytes.NewReader(data), secret)}
+	err := bits.Put(input, keys, conf)
+	if err != nil {
+		t.Fatalf("couldnt split for test prep: %v", err)
+	}
+
+	if len(keys.Keys) < 3 {
+		t.Fatalf("expected at least 3 chunks for the test, got: %d", len(keys.Keys))
+	}
+
+	_, err = bits.NewReader(keys.Keys, input.sizes[1:], conf)
+	if err == nil {
+		t.Error("expected reader without a size for each key to fail")
+	}
+
+	r, err := bits.NewReader(keys.Keys, input.sizes, conf)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+
+	if r.Size() != int64(len(data)) {
+		t.Fatalf("expected reader size to be %d, got: %d", len(data), r.Size())
+	}
+
+	t.Run("read_tail", func(t *testing.T) {
+		_, err := r.Seek(-100, io.SeekEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tail, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(tail, data[len(data)-100:]) {
+			t.Error("expected tail to equal the last 100 bytes of the input")
+		}
+
+		if store.gets != 1 {
+			t.Errorf("expected only the last chunk to be fetched, got %d fetches", store.gets)
+		}
+	})
+
+	t.Run("read_across_chunks", func(t *testing.T) {
+		off := input.sizes[0] - 10
+		p := make([]byte, input.sizes[1]+20)
+		n, err := r.ReadAt(p, off)
+		if err != nil || n != len(p) {
+			t.Fatalf("expected to read %d bytes, got: %d (%v)", len(p), n, err)
+		}
+
+		if !bytes.Equal(p, data[off:off+int64(n)]) {
+			t.Error("expected data that spans three chunks to equal the input")
+		}
+	})
+
+	t.Run("read_random_ranges", func(t *testing.T) {
+		for i := 0; i < 50; i++ {
+			off := rand.Int63n(int64(len(data)))
+			p := make([]byte, rand.Intn(2*1024*1024))
+			n, err := r.ReadAt(p, off)
+			if err != nil && err != io.EOF {
+				t.Fatal(err)
+			}
+
+			if err == io.EOF && off+int64(len(p)) <= int64(len(data)) {
+				t.Fatalf("unexpected EOF for range %d-%d", off, off+int64(len(p)))
+			}
+
+			if !bytes.Equal(p[:n], data[off:off+int64(n)]) {
+				t.Fatalf("expected range %d-%d to equal the input", off, off+int64(n))
+			}
+		}
+	})
+
+	t.Run("read_all", func(t *testing.T) {
+		_, err := r.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		all, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(all, data) {
+			t.Error("expected all data to equal the input")
+		}
+	})
+
+	t.Run("invalid_positions", func(t *testing.T) {
+		if _, err := r.Seek(-1, io.SeekStart); err == nil {
+			t.Error("expected seeking to a negative position to fail")
+		}
+
+		if _, err := r.ReadAt(make([]byte, 1), -1); err == nil {
+			t.Error("expected reading at a negative offset to fail")
+		}
+
+		if n, err := r.ReadAt(make([]byte, 1), r.Size()); n != 0 || err != io.EOF {
+			t.Errorf("expected reading at the end to return EOF, got: %d (%v)", n, err)
+		}
+	})
+}
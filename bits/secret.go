@@ -1,7 +1,9 @@
 package bits
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
@@ -30,6 +32,22 @@ func (s Secret) Pol() (p chunker.Pol) {
 	return chunker.Pol(i)
 }
 
+//Gear returns a table of 256 pseudo-random values that is derived from
+//the secret, it can be used for content-defined chunking with a gear hash
+func (s Secret) Gear() (g [256]uint64) {
+	key := deriveKey(s, gearLabel)
+	for i := 0; i < len(g); i += sha256.Size / 8 {
+		mac := hmac.New(sha256.New, key)
+		binary.Write(mac, binary.BigEndian, uint64(i))
+		sum := mac.Sum(nil)
+		for j := 0; j < sha256.Size/8; j++ {
+			g[i+j] = binary.BigEndian.Uint64(sum[j*8:])
+		}
+	}
+
+	return g
+}
+
 //Encode a secret into a byte slice
 func (s Secret) Encode() (b []byte) {
 	b = make([]byte, base64.URLEncoding.EncodedLen(len(s)))
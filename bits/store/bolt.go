@@ -1,6 +1,7 @@
 package bitsstore
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"time"
@@ -13,8 +14,51 @@ import (
 var (
 	//BoltChunkBucket is the name of the bucket that holds all chunks
 	BoltChunkBucket = []byte("chunks")
+
+	//BoltMetaBucket is the name of the bucket that holds named values
+	//about the store itself
+	BoltMetaBucket = []byte("meta")
+
+	//BoltTimeBucket is the name of the bucket that holds when each chunk
+	//was last put, such that recent chunks survive garbage collection
+	BoltTimeBucket = []byte("times")
 )
 
+//BoltStoreConfig configures a bolt store
+type BoltStoreConfig struct {
+	Path string `json:"bolt_path,omitempty"`
+}
+
+//Validate the bolt store configuration
+func (conf BoltStoreConfig) Validate() error {
+	if conf.Path == "" {
+		return &ValidationError{"bolt", "bolt_path", "a path to the database file is required"}
+	}
+
+	return nil
+}
+
+//CreateBoltStore is the store factory for the 'bolt' kind
+func CreateBoltStore(settings StoreSettings) (s bits.Store, err error) {
+	conf := BoltStoreConfig{}
+	err = settings.Decode(&conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bolt store settings: %v", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err = NewBoltStore(conf.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local bolt store: %v", err)
+	}
+
+	return s, nil
+}
+
 //BoltStore stores chunks into a mmap file using a B+tree
 type BoltStore struct {
 	DB *bolt.DB
@@ -31,6 +75,16 @@ func NewBoltStore(p string) (s *BoltStore, err error) {
 
 	err = s.DB.Update(func(tx *bolt.Tx) error {
 		_, err = tx.CreateBucketIfNotExists(BoltChunkBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(BoltMetaBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(BoltTimeBucket)
 		return err
 	})
 
@@ -41,14 +95,37 @@ func NewBoltStore(p string) (s *BoltStore, err error) {
 	return s, nil
 }
 
-//Put a new chunk 'chunk' with key 'k' into the store
+//Close the database file, the store cannot be used afterwards
+func (s *BoltStore) Close() error {
+	return s.DB.Close()
+}
+
+//Latency returns that the store holds chunks on a local disk
+func (s *BoltStore) Latency() bits.Latency {
+	return bits.LatencyDisk
+}
+
+//Put a new chunk 'chunk' with key 'k' into the store, the time at which a
+//chunk was put is recorded even if it existed already
 func (s *BoltStore) Put(k bits.K, chunk []byte) (err error) {
+	mtime := make([]byte, 8)
+	binary.BigEndian.PutUint64(mtime, uint64(time.Now().UnixNano()))
 	return s.DB.Batch(func(tx *bolt.Tx) error {
 		b := tx.Bucket(BoltChunkBucket)
 		if b == nil {
 			return fmt.Errorf("chunk bucket '%s' must first be created", string(BoltChunkBucket))
 		}
 
+		tb := tx.Bucket(BoltTimeBucket)
+		if tb == nil {
+			return fmt.Errorf("time bucket '%s' must first be created", string(BoltTimeBucket))
+		}
+
+		err := tb.Put(k[:], mtime)
+		if err != nil {
+			return err
+		}
+
 		existing := b.Get(k[:])
 		if existing != nil {
 			return nil
@@ -79,3 +156,104 @@ func (s *BoltStore) Get(k bits.K) (chunk []byte, err error) {
 
 	return chunk, err
 }
+
+//Has returns whether the store holds a chunk under 'k'
+func (s *BoltStore) Has(k bits.K) (ok bool, err error) {
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltChunkBucket)
+		if b == nil {
+			return fmt.Errorf("chunk bucket '%s' must first be created", string(BoltChunkBucket))
+		}
+
+		ok = len(b.Get(k[:])) > 0
+		return nil
+	})
+
+	return ok, err
+}
+
+//Delete removes the chunk under 'k' from the store, if any
+func (s *BoltStore) Delete(k bits.K) (err error) {
+	return s.DB.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltChunkBucket)
+		if b == nil {
+			return fmt.Errorf("chunk bucket '%s' must first be created", string(BoltChunkBucket))
+		}
+
+		tb := tx.Bucket(BoltTimeBucket)
+		if tb == nil {
+			return fmt.Errorf("time bucket '%s' must first be created", string(BoltTimeBucket))
+		}
+
+		err := tb.Delete(k[:])
+		if err != nil {
+			return err
+		}
+
+		return b.Delete(k[:])
+	})
+}
+
+//List calls 'fn' with each chunk in the store, chunks that were put before
+//put times were recorded have a zero ModTime
+func (s *BoltStore) List(fn func(info bits.ChunkInfo) error) (err error) {
+	return s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltChunkBucket)
+		if b == nil {
+			return fmt.Errorf("chunk bucket '%s' must first be created", string(BoltChunkBucket))
+		}
+
+		tb := tx.Bucket(BoltTimeBucket)
+		if tb == nil {
+			return fmt.Errorf("time bucket '%s' must first be created", string(BoltTimeBucket))
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != bits.KeySize {
+				return nil
+			}
+
+			info := bits.ChunkInfo{Size: int64(len(v))}
+			copy(info.K[:], k)
+			if mtime := tb.Get(k); len(mtime) == 8 {
+				info.ModTime = time.Unix(0, int64(binary.BigEndian.Uint64(mtime)))
+			}
+
+			return fn(info)
+		})
+	})
+}
+
+//PutMeta stores value 'v' under 'name'
+func (s *BoltStore) PutMeta(name string, v []byte) (err error) {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltMetaBucket)
+		if b == nil {
+			return fmt.Errorf("meta bucket '%s' must first be created", string(BoltMetaBucket))
+		}
+
+		return b.Put([]byte(name), v)
+	})
+}
+
+//GetMeta returns the value stored under 'name', returns an os.ErrNotExist
+//if no value was stored under the name
+func (s *BoltStore) GetMeta(name string) (v []byte, err error) {
+	err = s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltMetaBucket)
+		if b == nil {
+			return fmt.Errorf("meta bucket '%s' must first be created", string(BoltMetaBucket))
+		}
+
+		data := b.Get([]byte(name))
+		if data == nil {
+			return os.ErrNotExist
+		}
+
+		v = make([]byte, len(data))
+		copy(v, data)
+		return nil
+	})
+
+	return v, err
+}
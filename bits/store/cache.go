@@ -0,0 +1,345 @@
+package bitsstore
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	//BoltCacheBucket is the name of the bucket that holds a nested bucket
+	//for each local store with the size and last access time of each chunk
+	//that the cache store copied into it from its remote
+	BoltCacheBucket = []byte("cache")
+)
+
+//cacheEntry describes a chunk that was copied from the remote
+type cacheEntry struct {
+	k     bits.K
+	size  int64
+	atime time.Time
+}
+
+func (e *cacheEntry) encode() []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v[0:], uint64(e.size))
+	binary.BigEndian.PutUint64(v[8:], uint64(e.atime.UnixNano()))
+	return v
+}
+
+//CacheStore reads chunks through a fast local store, chunks that are only
+//found in the slow remote are copied into the local store. Once the copied
+//chunks take more than the configured number of bytes the least recently
+//used are removed from the local store. Only chunks that were copied from the
+//remote are removed, chunks that were put into the local store in any other
+//way may not have been moved to a remote yet and are never removed. What was
+//copied is recorded in a bolt database per local store such that it survives
+//the process
+type CacheStore struct {
+	local   bits.DeleteStore
+	remote  bits.Store
+	db      *bolt.DB
+	name    []byte
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List //most recently used at the front
+	entries map[bits.K]*list.Element
+	dirty   map[bits.K]struct{}
+}
+
+//NewCacheStore creates a store that caches chunks of 'remote' in 'local' up
+//to 'maxSize' bytes, what was cached is recorded in 'db' under the 'name' of
+//the local store. The local store must support deleting chunks
+func NewCacheStore(local, remote bits.Store, db *bolt.DB, name string, maxSize int64) (s *CacheStore, err error) {
+	dlocal, ok := local.(bits.DeleteStore)
+	if !ok {
+		return nil, fmt.Errorf("local store of type %T cannot delete chunks", local)
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("cache requires a non-empty local store name")
+	}
+
+	s = &CacheStore{
+		local:   dlocal,
+		remote:  remote,
+		db:      db,
+		name:    []byte(name),
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[bits.K]*list.Element{},
+		dirty:   map[bits.K]struct{}{},
+	}
+
+	loaded := []*cacheEntry{}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(BoltCacheBucket)
+		if err != nil {
+			return err
+		}
+
+		b, err = b.CreateBucketIfNotExists(s.name)
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != bits.KeySize || len(v) != 16 {
+				return fmt.Errorf("malformed cache entry")
+			}
+
+			e := &cacheEntry{
+				size:  int64(binary.BigEndian.Uint64(v[0:])),
+				atime: time.Unix(0, int64(binary.BigEndian.Uint64(v[8:]))),
+			}
+
+			copy(e.k[:], k)
+			loaded = append(loaded, e)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cache entries: %v", err)
+	}
+
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].atime.Before(loaded[j].atime) })
+	for _, e := range loaded {
+		s.entries[e.k] = s.lru.PushFront(e)
+		s.size += e.size
+	}
+
+	err = s.evict()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+//Size returns the number of bytes that chunks copied from the remote take
+func (s *CacheStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+//touch marks a cached chunk as used, its access time is written lazily
+func (s *CacheStore) touch(k bits.K) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[k]
+	if !ok {
+		return
+	}
+
+	el.Value.(*cacheEntry).atime = time.Now()
+	s.lru.MoveToFront(el)
+	s.dirty[k] = struct{}{}
+}
+
+//add records a chunk that was copied from the remote and evicts the least
+//recently used chunks if the cache grew too large
+func (s *CacheStore) add(k bits.K, size int64) error {
+	s.mu.Lock()
+	if _, ok := s.entries[k]; ok {
+		s.mu.Unlock()
+		s.touch(k)
+		return nil
+	}
+
+	s.entries[k] = s.lru.PushFront(&cacheEntry{k: k, size: size, atime: time.Now()})
+	s.size += size
+	s.dirty[k] = struct{}{}
+	s.mu.Unlock()
+	return s.evict()
+}
+
+//evict removes the least recently used chunks from the local store until
+//the cache is small enough, it also writes access times
+func (s *CacheStore) evict() error {
+	s.mu.Lock()
+	evicted := []*cacheEntry{}
+	for s.size > s.maxSize && s.lru.Len() > 0 {
+		e := s.lru.Remove(s.lru.Back()).(*cacheEntry)
+		delete(s.entries, e.k)
+		delete(s.dirty, e.k)
+		s.size -= e.size
+		evicted = append(evicted, e)
+	}
+
+	s.mu.Unlock()
+	for _, e := range evicted {
+		err := s.local.Delete(e.k)
+		if err != nil {
+			return fmt.Errorf("failed to evict chunk '%s' from cache: %v", e.k, err)
+		}
+	}
+
+	return s.flush(evicted)
+}
+
+//flush writes the entries that changed and removes those that were evicted
+func (s *CacheStore) flush(evicted []*cacheEntry) error {
+	s.mu.Lock()
+	changed := map[bits.K][]byte{}
+	for k := range s.dirty {
+		changed[k] = s.entries[k].Value.(*cacheEntry).encode()
+	}
+
+	s.dirty = map[bits.K]struct{}{}
+	s.mu.Unlock()
+	if len(changed) == 0 && len(evicted) == 0 {
+		return nil
+	}
+
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range evicted {
+			if err := b.Delete(e.k[:]); err != nil {
+				return err
+			}
+		}
+
+		for k, v := range changed {
+			if err := b.Put(k[:], v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to record cache entries: %v", err)
+	}
+
+	return nil
+}
+
+func (s *CacheStore) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	b := tx.Bucket(BoltCacheBucket)
+	if b == nil {
+		return nil, fmt.Errorf("cache bucket '%s' must first be created", string(BoltCacheBucket))
+	}
+
+	b = b.Bucket(s.name)
+	if b == nil {
+		return nil, fmt.Errorf("cache of local store '%s' must first be created", string(s.name))
+	}
+
+	return b, nil
+}
+
+//forget stops tracking chunk 'k' as a copy, it is never evicted
+func (s *CacheStore) forget(k bits.K) error {
+	s.mu.Lock()
+	el, ok := s.entries[k]
+	if ok {
+		s.lru.Remove(el)
+		delete(s.entries, k)
+		delete(s.dirty, k)
+		s.size -= el.Value.(*cacheEntry).size
+	}
+
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return b.Delete(k[:])
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to forget cache entry '%s': %v", k, err)
+	}
+
+	return nil
+}
+
+//Latency returns the latency of the local store, which answers first
+func (s *CacheStore) Latency() bits.Latency {
+	if ls, ok := s.local.(bits.LatencyStore); ok {
+		return ls.Latency()
+	}
+
+	return bits.LatencyDisk
+}
+
+//Put a chunk into the local store, it is never evicted. Also not when it
+//was copied from the remote before
+func (s *CacheStore) Put(k bits.K, chunk []byte) error {
+	err := s.local.Put(k, chunk)
+	if err != nil {
+		return err
+	}
+
+	return s.forget(k)
+}
+
+//Get a chunk from the local store, or from the remote if the local store
+//doesn't hold it in which case it is copied into the local store
+func (s *CacheStore) Get(k bits.K) (chunk []byte, err error) {
+	return s.GetContext(context.Background(), k)
+}
+
+//GetContext is like Get but aborts getting the chunk from the remote when
+//the context is done. Only chunks that the local store reports to not hold
+//are copied, such that the cache only evicts chunks it inserted itself
+func (s *CacheStore) GetContext(ctx context.Context, k bits.K) (chunk []byte, err error) {
+	chunk, err = s.local.Get(k)
+	if err == nil {
+		s.touch(k)
+		return chunk, nil
+	}
+
+	missing := os.IsNotExist(err)
+
+	if cs, ok := s.remote.(bits.ContextStore); ok {
+		chunk, err = cs.GetContext(ctx, k)
+	} else if err = ctx.Err(); err == nil {
+		chunk, err = s.remote.Get(k)
+	}
+
+	if err != nil || !missing {
+		return chunk, err
+	}
+
+	err = s.local.Put(k, chunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy chunk '%s' into cache: %v", k, err)
+	}
+
+	err = s.add(k, int64(len(chunk)))
+	if err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
+
+//Close writes the access times of chunks that were used
+func (s *CacheStore) Close() error {
+	return s.flush(nil)
+}
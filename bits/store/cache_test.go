@@ -0,0 +1,92 @@
+package bitsstore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestCacheStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits_cache_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	local, err := bitsstore.NewBoltStore(filepath.Join(dir, "db.bolt"))
+	if err != nil {
+		t.Fatalf("failed to create bolt store: %v", err)
+	}
+
+	remote := bitsstore.NewMemStore()
+	a, b, c, p := bits.K{0x01}, bits.K{0x02}, bits.K{0x03}, bits.K{0x04}
+	for _, k := range []bits.K{a, b, c} {
+		remote.Put(k, randb(100))
+	}
+
+	//chunks that were put locally may not have been moved yet
+	local.Put(p, randb(100))
+	cache, err := bitsstore.NewCacheStore(local, remote, local.DB, "local", 250)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	for _, k := range []bits.K{a, b, a, c, p} {
+		if _, err = cache.Get(k); err != nil {
+			t.Fatalf("failed to get chunk '%s': %v", k, err)
+		}
+	}
+
+	has := func(k bits.K) bool {
+		ok, err := local.Has(k)
+		if err != nil {
+			t.Fatalf("failed to check local store: %v", err)
+		}
+
+		return ok
+	}
+
+	if !has(a) || has(b) || !has(c) || !has(p) || cache.Size() != 200 {
+		t.Fatalf("expected least recently used chunk to be evicted, got: %v %v %v %v, %d bytes", has(a), has(b), has(c), has(p), cache.Size())
+	}
+
+	if err = cache.Close(); err != nil {
+		t.Fatalf("failed to close cache: %v", err)
+	}
+
+	//entries are recorded per local store, the cache of another store
+	//doesn't evict them
+	other, err := bitsstore.NewCacheStore(local, remote, local.DB, "other", 0)
+	if err != nil || other.Size() != 0 || !has(a) || !has(c) {
+		t.Fatalf("expected cache of another store to be empty, got %d bytes (%v)", other.Size(), err)
+	}
+
+	//entries are recorded, a smaller cache evicts them when opened
+	cache, err = bitsstore.NewCacheStore(local, remote, local.DB, "local", 150)
+	if err != nil {
+		t.Fatalf("failed to reopen cache: %v", err)
+	}
+
+	if has(a) || !has(c) || !has(p) || cache.Size() != 100 {
+		t.Errorf("expected recorded chunk to be evicted, got: %v %v %v, %d bytes", has(a), has(c), has(p), cache.Size())
+	}
+
+	//a copied chunk that is put is never evicted
+	if err = cache.Put(c, randb(100)); err != nil || cache.Size() != 0 {
+		t.Fatalf("expected put chunk to no longer be cached, got %d bytes (%v)", cache.Size(), err)
+	}
+
+	cache, err = bitsstore.NewCacheStore(local, remote, local.DB, "local", 0)
+	if err != nil || !has(c) || !has(p) {
+		t.Errorf("expected put chunks to be kept, got: %v %v (%v)", has(c), has(p), err)
+	}
+
+	_, err = bitsstore.NewCacheStore(struct{ bits.Store }{local}, remote, local.DB, "local", 150)
+	if err == nil {
+		t.Errorf("expected error for local store that cannot delete")
+	}
+}
@@ -0,0 +1,231 @@
+package bitsstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+)
+
+//DirStoreConfig configures a directory store
+type DirStoreConfig struct {
+	Path string `json:"dir_path,omitempty"`
+}
+
+//Validate the directory store configuration
+func (conf DirStoreConfig) Validate() error {
+	if conf.Path == "" {
+		return &ValidationError{"dir", "dir_path", "a path to the directory is required"}
+	}
+
+	return nil
+}
+
+//CreateDirStore is the store factory for the 'dir' kind
+func CreateDirStore(settings StoreSettings) (s bits.Store, err error) {
+	conf := DirStoreConfig{}
+	err = settings.Decode(&conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode dir store settings: %v", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err = NewDirStore(conf.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local dir store: %v", err)
+	}
+
+	return s, nil
+}
+
+//DirStore stores each chunk as a single file in a directory tree that is
+//sharded by the first two characters of the encoded key. Unlike the bolt
+//store it allows concurrent writers, frees disk space when files are removed
+//and can be synchronized with standard tools.
+type DirStore struct {
+	Dir string
+}
+
+//NewDirStore opens a store in directory 'dir', the directory is created
+//if it doesn't exist yet
+func NewDirStore(dir string) (s *DirStore, err error) {
+	s = &DirStore{Dir: dir}
+	err = os.MkdirAll(s.tmpDir(), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directories: %v", err)
+	}
+
+	return s, nil
+}
+
+//temporary files are written in the store directory to make sure
+//they reside on the same filesystem for an atomic rename
+func (s *DirStore) tmpDir() string {
+	return filepath.Join(s.Dir, "tmp")
+}
+
+func (s *DirStore) metaPath(name string) string {
+	return filepath.Join(s.Dir, "meta", name)
+}
+
+func (s *DirStore) chunkPath(k bits.K) string {
+	name := k.String()
+	return filepath.Join(s.Dir, name[:2], name)
+}
+
+//Latency returns that the store holds chunks on a local disk
+func (s *DirStore) Latency() bits.Latency {
+	return bits.LatencyDisk
+}
+
+//Put a new chunk 'chunk' with key 'k' into the store by writing it to a
+//temporary file first and renaming it into place. The modification time
+//of a chunk file that exists is updated instead
+func (s *DirStore) Put(k bits.K, chunk []byte) (err error) {
+	p := s.chunkPath(k)
+	if _, err = os.Stat(p); err == nil {
+		now := time.Now()
+		return os.Chtimes(p, now, now)
+	}
+
+	return s.writeFile(p, chunk)
+}
+
+//writeFile writes 'data' to a temporary file first and renames it to 'p'
+//such that readers never observe a partially written file
+func (s *DirStore) writeFile(p string, data []byte) (err error) {
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	f, err := ioutil.TempFile(s.tmpDir(), "chunk_")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write temporary file: %v", err)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync temporary file: %v", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temporary file: %v", err)
+	}
+
+	err = os.Rename(f.Name(), p)
+	if err != nil {
+		return fmt.Errorf("failed to move file into place: %v", err)
+	}
+
+	return nil
+}
+
+//Get an existhing with 'k' from the store, returns an os.ErrNotExist if
+//no chunk with the given key exists in this store.
+func (s *DirStore) Get(k bits.K) (chunk []byte, err error) {
+	chunk, err = ioutil.ReadFile(s.chunkPath(k))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+
+		return nil, fmt.Errorf("failed to read chunk file: %v", err)
+	}
+
+	return chunk, nil
+}
+
+//Has returns whether the chunk file of 'k' exists
+func (s *DirStore) Has(k bits.K) (ok bool, err error) {
+	_, err = os.Stat(s.chunkPath(k))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to stat chunk file: %v", err)
+	}
+
+	return true, nil
+}
+
+//Delete removes the chunk file of 'k', if any
+func (s *DirStore) Delete(k bits.K) (err error) {
+	err = os.Remove(s.chunkPath(k))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove chunk file: %v", err)
+	}
+
+	return nil
+}
+
+//List calls 'fn' with each chunk file in the store, files in the shard
+//directories whose name isn't a key are skipped
+func (s *DirStore) List(fn func(info bits.ChunkInfo) error) (err error) {
+	shards, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read store directory: %v", err)
+	}
+
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+
+		fis, err := ioutil.ReadDir(filepath.Join(s.Dir, shard.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read shard directory: %v", err)
+		}
+
+		for _, fi := range fis {
+			k, err := bits.DecodeKey([]byte(fi.Name()))
+			if err != nil || fi.IsDir() {
+				continue
+			}
+
+			err = fn(bits.ChunkInfo{K: k, Size: fi.Size(), ModTime: fi.ModTime()})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//PutMeta stores value 'v' under 'name' as a file in the meta directory
+func (s *DirStore) PutMeta(name string, v []byte) (err error) {
+	return s.writeFile(s.metaPath(name), v)
+}
+
+//GetMeta returns the value stored under 'name', returns an os.ErrNotExist
+//if no value was stored under the name
+func (s *DirStore) GetMeta(name string) (v []byte, err error) {
+	v, err = ioutil.ReadFile(s.metaPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+
+		return nil, fmt.Errorf("failed to read meta file: %v", err)
+	}
+
+	return v, nil
+}
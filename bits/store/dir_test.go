@@ -0,0 +1,104 @@
+package bitsstore_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestDirStorePutGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits_dir_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	store, err := bitsstore.NewDirStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create dir store: %v", err)
+	}
+
+	_, err = store.Get(bits.K{})
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for unknown key, got: %v", err)
+	}
+
+	input := randb(1024 * 1024)
+	k := bits.K(sha256.Sum256(input))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Put(k, input); err != nil {
+				t.Errorf("failed to put chunk '%s': %v", k, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	output, err := store.Get(k)
+	if err != nil {
+		t.Fatalf("failed to get chunk '%s': %v", k, err)
+	}
+
+	if !bytes.Equal(output, input) {
+		t.Fatal("expected input and output to be the same")
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, k.String()[:2], k.String())); err != nil {
+		t.Errorf("expected chunk file to be stored in shard directory: %v", err)
+	}
+
+	if ok, err := store.Has(k); !ok || err != nil {
+		t.Errorf("expected store to have chunk, got: %v, %v", ok, err)
+	}
+
+	if ok, err := store.Has(bits.K{}); ok || err != nil {
+		t.Errorf("expected store not to have unknown chunk, got: %v, %v", ok, err)
+	}
+
+	tmps, err := ioutil.ReadDir(filepath.Join(dir, "tmp"))
+	if err != nil || len(tmps) != 0 {
+		t.Errorf("expected no temporary files to be left behind, got: %d (%v)", len(tmps), err)
+	}
+}
+
+func TestDirStoreMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits_dir_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	var store bits.MetaStore
+	store, err = bitsstore.NewDirStore(dir)
+	if err != nil {
+		t.Fatalf("failed to create dir store: %v", err)
+	}
+
+	_, err = store.GetMeta("foo")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for unknown meta, got: %v", err)
+	}
+
+	for _, v := range []string{"bar", "baz"} {
+		err = store.PutMeta("foo", []byte(v))
+		if err != nil {
+			t.Fatalf("failed to put meta: %v", err)
+		}
+
+		output, err := store.GetMeta("foo")
+		if err != nil || string(output) != v {
+			t.Errorf("expected meta to be '%s', got: '%s' (%v)", v, output, err)
+		}
+	}
+}
@@ -2,39 +2,85 @@ package bitsstore
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/advanderveer/libchunk/bits"
 )
 
-//SupportedStores holds identifiers for all supported stores
-var SupportedStores = []string{"bolt", "mem"}
-
-//CreateStore a store instance for any of the supported types
-func CreateStore(stype string, boltPath string) (s bits.Store, err error) {
-	sname := ""
-	for _, supported := range SupportedStores {
-		if supported == stype {
-			sname = supported
-			break
-		}
+//SupportedStores holds identifiers for all supported stores, it is
+//kept up-to-date as store kinds are registered
+var SupportedStores = []string{}
+
+var (
+	factoriesMu sync.Mutex
+	factories   = map[string]StoreFactory{}
+)
+
+func init() {
+	RegisterStore("mem", CreateMemStore)
+	RegisterStore("bolt", CreateBoltStore)
+	RegisterStore("dir", CreateDirStore)
+	RegisterStore("s3", CreateS3Remote)
+	RegisterStore("pack", CreatePackRemote)
+}
+
+//StoreSettings provides a factory with the kind specific settings of the
+//store it should create
+type StoreSettings interface {
+
+	//decodes the settings into 'v', a pointer to the kind specific
+	//settings structure, e.g: *BoltStoreConfig
+	Decode(v interface{}) error
+
+	//returns the (decrypted) secret that is configured under 'name'
+	Secret(name string) (secret string, ok bool)
+}
+
+//StoreFactory creates a store from its settings. A factory may return a
+//bits.RemoteStore if the store supports indexing
+type StoreFactory func(settings StoreSettings) (bits.Store, error)
+
+//ValidationError is returned by a factory when the settings of a store
+//are invalid
+type ValidationError struct {
+	Kind   string
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid '%s' setting for %s store: %s", e.Field, e.Kind, e.Reason)
+}
+
+//RegisterStore makes a store kind available for creation through
+//CreateStore. It panics if the kind was already registered, this allows
+//other packages to provide store kinds in their init function
+func RegisterStore(kind string, factory StoreFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("bitsstore: store factory for kind '" + kind + "' is nil")
 	}
 
-	if sname == "" {
-		return nil, fmt.Errorf("store type '%s' is not supported, available store types are: %v", stype, SupportedStores)
+	if _, ok := factories[kind]; ok {
+		panic("bitsstore: store kind '" + kind + "' is registered twice")
 	}
 
-	//maps factory args unto actual store creation
-	switch sname {
-	case "mem":
-		return NewMemStore(), nil
-	case "bolt":
-		s, err = NewBoltStore(boltPath)
-		if err != nil {
-			return s, fmt.Errorf("failed to open local bolt store: %v", err)
-		}
-
-		return s, nil
-	default:
-		return nil, fmt.Errorf("store type '%s' is not currently implemented", stype)
+	factories[kind] = factory
+	SupportedStores = append(SupportedStores, kind)
+	sort.Strings(SupportedStores)
+}
+
+//CreateStore a store instance for any of the registered kinds
+func CreateStore(kind string, settings StoreSettings) (s bits.Store, err error) {
+	factoriesMu.Lock()
+	factory, ok := factories[kind]
+	supported := append([]string{}, SupportedStores...)
+	factoriesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("store kind '%s' is not supported, available store kinds are: %v", kind, supported)
 	}
+
+	return factory(settings)
 }
@@ -0,0 +1,104 @@
+package bitsstore_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+type jsonSettings struct {
+	data    string
+	secrets map[string]string
+}
+
+func (s *jsonSettings) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(s.data), v)
+}
+
+func (s *jsonSettings) Secret(name string) (secret string, ok bool) {
+	secret, ok = s.secrets[name]
+	return secret, ok
+}
+
+//the test kind is registered once, registering it again panics
+func init() {
+	bitsstore.RegisterStore("test-kind", func(settings bitsstore.StoreSettings) (bits.Store, error) {
+		conf := struct {
+			Size int `json:"test_size"`
+		}{}
+
+		if err := settings.Decode(&conf); err != nil {
+			return nil, err
+		}
+
+		if conf.Size < 1 {
+			return nil, &bitsstore.ValidationError{"test-kind", "test_size", "must be positive"}
+		}
+
+		return bitsstore.NewMemStore(), nil
+	})
+}
+
+func TestCreateStore(t *testing.T) {
+	cases := []struct {
+		name     string
+		kind     string
+		settings *jsonSettings
+		invalid  bool
+		failed   bool
+	}{
+		{"mem", "mem", &jsonSettings{data: `{}`}, false, false},
+		{"unknown_kind", "foo", &jsonSettings{data: `{}`}, false, true},
+		{"bolt_without_path", "bolt", &jsonSettings{data: `{}`}, true, true},
+		{"s3_without_host", "s3", &jsonSettings{data: `{}`}, true, true},
+		{"s3_invalid_scheme", "s3", &jsonSettings{data: `{"s3_host": "localhost", "s3_scheme": "ftp"}`}, true, true},
+		{"s3_without_secret", "s3", &jsonSettings{data: `{"s3_host": "localhost", "s3_access_key": "my-key"}`}, true, true},
+		{"s3_public", "s3", &jsonSettings{data: `{"s3_host": "localhost"}`}, false, false},
+		{"s3_invalid_max_retries", "s3", &jsonSettings{data: `{"s3_host": "localhost", "s3_max_retries": -2}`}, true, true},
+		{"s3_without_retries", "s3", &jsonSettings{data: `{"s3_host": "localhost", "s3_max_retries": -1}`}, false, false},
+		{"s3_with_secret", "s3", &jsonSettings{`{"s3_host": "localhost", "s3_access_key": "my-key", "s3_secret_key_name": "foo"}`, map[string]string{"foo": "bar"}}, false, false},
+		{"pack_without_index_path", "pack", &jsonSettings{`{"s3_host": "localhost"}`, map[string]string{"pack_secret": "bar"}}, true, true},
+		{"pack_invalid_size", "pack", &jsonSettings{`{"s3_host": "localhost", "pack_index_path": "/tmp/packs.bolt", "pack_size_mib": 128}`, map[string]string{"pack_secret": "bar"}}, true, true},
+		{"pack_without_secret", "pack", &jsonSettings{data: `{"s3_host": "localhost", "pack_index_path": "/tmp/packs.bolt"}`}, true, true},
+		{"third_party", "test-kind", &jsonSettings{data: `{"test_size": 10}`}, false, false},
+		{"third_party_invalid", "test-kind", &jsonSettings{data: `{"test_size": 0}`}, true, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := bitsstore.CreateStore(c.kind, c.settings)
+			if err != nil {
+				if !c.failed {
+					t.Fatalf("expected store creation to succeed, got: %v", err)
+				}
+
+				if _, ok := err.(*bitsstore.ValidationError); ok != c.invalid {
+					t.Errorf("expected validation error to be %v, got: %v", c.invalid, err)
+				}
+
+				return
+			} else if c.failed {
+				t.Fatal("expected store creation to fail")
+			}
+
+			if s == nil {
+				t.Error("expected a store to be created")
+			}
+		})
+	}
+
+	if _, ok := mustCreate(t, "s3", `{"s3_host": "localhost"}`).(bits.RemoteStore); !ok {
+		t.Error("expected s3 store to be a remote store")
+	}
+}
+
+func mustCreate(t *testing.T, kind, settings string) bits.Store {
+	s, err := bitsstore.CreateStore(kind, &jsonSettings{data: settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
@@ -0,0 +1,124 @@
+package bitsstore
+
+import (
+	"fmt"
+
+	"github.com/advanderveer/libchunk/bits"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	//BoltJournalBucket is the name of the bucket that holds a nested
+	//bucket with the recorded keys of each transfer
+	BoltJournalBucket = []byte("journals")
+)
+
+//BoltJournal records the keys of a transfer in a bolt database such that
+//an interrupted transfer can be resumed. It implements the bits.KeyIndex
+//interface so it can be configured as the journal of a move
+type BoltJournal struct {
+	db *bolt.DB
+	id []byte
+}
+
+//NewBoltJournal opens the journal of transfer 'id' in bolt database 'db',
+//the database can be shared with a BoltStore
+func NewBoltJournal(db *bolt.DB, id string) (j *BoltJournal, err error) {
+	if id == "" {
+		return nil, fmt.Errorf("journal requires a non-empty transfer id")
+	}
+
+	j = &BoltJournal{db: db, id: []byte(id)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(BoltJournalBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = b.CreateBucketIfNotExists(j.id)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal buckets: %v", err)
+	}
+
+	return j, nil
+}
+
+func (j *BoltJournal) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	b := tx.Bucket(BoltJournalBucket)
+	if b == nil {
+		return nil, fmt.Errorf("journal bucket '%s' must first be created", string(BoltJournalBucket))
+	}
+
+	b = b.Bucket(j.id)
+	if b == nil {
+		return nil, fmt.Errorf("journal of transfer '%s' must first be created", string(j.id))
+	}
+
+	return b, nil
+}
+
+//Write records key 'k' as transferred
+func (j *BoltJournal) Write(k bits.K) error {
+	return j.db.Batch(func(tx *bolt.Tx) error {
+		b, err := j.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(k[:], []byte{})
+	})
+}
+
+//Has returns whether key 'k' was recorded as transferred, a key is
+//considered not transferred if the journal cannot be read
+func (j *BoltJournal) Has(k bits.K) (ok bool) {
+	j.db.View(func(tx *bolt.Tx) error {
+		b, err := j.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		ok = b.Get(k[:]) != nil
+		return nil
+	})
+
+	return ok
+}
+
+//Count returns the number of keys that were recorded as transferred
+func (j *BoltJournal) Count() (n int, err error) {
+	err = j.db.View(func(tx *bolt.Tx) error {
+		b, err := j.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		n = b.Stats().KeyN
+		return nil
+	})
+
+	return n, err
+}
+
+//Clear removes all recorded keys from the journal, for example when a
+//transfer is started anew or completed successfully
+func (j *BoltJournal) Clear() error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltJournalBucket)
+		if b == nil {
+			return fmt.Errorf("journal bucket '%s' must first be created", string(BoltJournalBucket))
+		}
+
+		err := b.DeleteBucket(j.id)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		_, err = b.CreateBucket(j.id)
+		return err
+	})
+}
@@ -0,0 +1,59 @@
+package bitsstore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestBoltJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits_journal_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	store, err := bitsstore.NewBoltStore(filepath.Join(dir, "db.bolt"))
+	if err != nil {
+		t.Fatalf("failed to create bolt store: %v", err)
+	}
+
+	j1, err := bitsstore.NewBoltJournal(store.DB, "transfer-1")
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+
+	j2, err := bitsstore.NewBoltJournal(store.DB, "transfer-2")
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+
+	k := bits.K{0x01}
+	if j1.Has(k) {
+		t.Error("expected empty journal to not have key")
+	}
+
+	if err = j1.Write(k); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+
+	if !j1.Has(k) || j2.Has(k) {
+		t.Error("expected key to be recorded in the first journal only")
+	}
+
+	if n, err := j1.Count(); err != nil || n != 1 {
+		t.Errorf("expected one key in journal, got: %d (%v)", n, err)
+	}
+
+	if err = j1.Clear(); err != nil {
+		t.Fatalf("failed to clear journal: %v", err)
+	}
+
+	if j1.Has(k) {
+		t.Error("expected cleared journal to not have key")
+	}
+}
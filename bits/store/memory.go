@@ -8,7 +8,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/advanderveer/libchunk/bits"
 )
@@ -19,22 +21,40 @@ import (
 //for the S3 remote.
 type MemStore struct {
 	*sync.Mutex
-	Chunks map[bits.K][]byte
+	Chunks  map[bits.K][]byte
+	Times   map[bits.K]time.Time
+	Meta    map[string][]byte
+	Objects map[string][]byte
 }
 
 //NewMemStore sets up an empty memory store
 func NewMemStore() *MemStore {
 	return &MemStore{
-		Mutex:  &sync.Mutex{},
-		Chunks: map[bits.K][]byte{},
+		Mutex:   &sync.Mutex{},
+		Chunks:  map[bits.K][]byte{},
+		Times:   map[bits.K]time.Time{},
+		Meta:    map[string][]byte{},
+		Objects: map[string][]byte{},
 	}
 }
 
-//Put a chunk into the Chunks map under the given 'k'
+//CreateMemStore is the store factory for the 'mem' kind, it has no settings
+func CreateMemStore(settings StoreSettings) (s bits.Store, err error) {
+	return NewMemStore(), nil
+}
+
+//Latency returns that the store holds chunks in memory
+func (s *MemStore) Latency() bits.Latency {
+	return bits.LatencyMemory
+}
+
+//Put a chunk into the Chunks map under the given 'k' and record the time
+//at which it was put
 func (s *MemStore) Put(k bits.K, chunk []byte) (err error) {
 	s.Lock()
 	defer s.Unlock()
 	s.Chunks[k] = chunk
+	s.Times[k] = time.Now()
 	return nil
 }
 
@@ -51,8 +71,128 @@ func (s *MemStore) Get(k bits.K) (chunk []byte, err error) {
 	return chunk, nil
 }
 
-func (s *MemStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+//Has returns whether the map holds a chunk under 'k'
+func (s *MemStore) Has(k bits.K) (ok bool, err error) {
+	s.Lock()
+	defer s.Unlock()
+	_, ok = s.Chunks[k]
+	return ok, nil
+}
+
+//Delete removes the chunk under 'k' from the map, if any
+func (s *MemStore) Delete(k bits.K) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.Chunks, k)
+	delete(s.Times, k)
+	return nil
+}
+
+//List calls 'fn' with each chunk in the map, chunks that were added to the
+//map directly have a zero ModTime
+func (s *MemStore) List(fn func(info bits.ChunkInfo) error) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	for k, chunk := range s.Chunks {
+		err = fn(bits.ChunkInfo{K: k, Size: int64(len(chunk)), ModTime: s.Times[k]})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//PutMeta stores value 'v' under 'name'
+func (s *MemStore) PutMeta(name string, v []byte) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.Meta[name] = v
+	return nil
+}
+
+//GetMeta returns the value stored under 'name'
+func (s *MemStore) GetMeta(name string) (v []byte, err error) {
+	s.Lock()
+	defer s.Unlock()
+	var ok bool
+	v, ok = s.Meta[name]
+	if !ok {
+		return v, os.ErrNotExist
+	}
+
+	return v, nil
+}
+
+//serveMeta handles requests for meta objects of the S3 remote
+func (s *MemStore) serveMeta(w http.ResponseWriter, r *http.Request, name string) {
 	if r.Method == "PUT" {
+		v, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		s.PutMeta(name, v)
+		return
+	}
+
+	v, err := s.GetMeta(name)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Write(v)
+}
+
+//serveObject handles requests for other objects of the S3 remote, e.g:
+//packs. Gets may request a range of the object
+func (s *MemStore) serveObject(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case "PUT":
+		v, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		s.Lock()
+		s.Objects[name] = v
+		s.Unlock()
+	case "DELETE":
+		s.Lock()
+		delete(s.Objects, name)
+		s.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.Lock()
+		v, ok := s.Objects[name]
+		s.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(v))
+	}
+}
+
+func (s *MemStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if name := strings.TrimPrefix(r.URL.Path, "/"+S3MetaPrefix+"/"); name != r.URL.Path {
+		s.serveMeta(w, r, name)
+	} else if name := strings.TrimPrefix(r.URL.Path, "/"+S3PackPrefix+"/"); name != r.URL.Path {
+		s.serveObject(w, r, S3PackPrefix+"/"+name)
+	} else if r.Method == "DELETE" {
+		k, err := bits.DecodeKey(bytes.TrimLeft([]byte(r.URL.String()), "/"))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		s.Delete(k)
+		w.WriteHeader(http.StatusNoContent)
+	} else if r.Method == "PUT" {
 		k, err := bits.DecodeKey(bytes.TrimLeft([]byte(r.URL.String()), "/"))
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -77,15 +217,37 @@ func (s *MemStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 <?xml version="1.0" encoding="UTF-8"?>
 <ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
 
+		prefix := r.URL.Query().Get("prefix")
 		s.Lock()
 		defer s.Unlock()
+		for name, v := range s.Objects {
+			if strings.HasPrefix(name, prefix) {
+				fmt.Fprintf(w, `
+	<Contents>
+		<Key>%s</Key>
+		<Size>%d</Size>
+	</Contents>
+			`, name, len(v))
+			}
+		}
+
 		for k, chunk := range s.Chunks {
+			if !strings.HasPrefix(k.String(), prefix) {
+				continue
+			}
+
+			mtime := s.Times[k]
+			if mtime.IsZero() {
+				mtime = time.Unix(0, 0)
+			}
+
 			fmt.Fprintf(w, `
 	<Contents>
 		<Key>%s</Key>
+		<LastModified>%s</LastModified>
 		<Size>%d</Size>
 	</Contents>
-			`, k, len(chunk))
+			`, k, mtime.UTC().Format(time.RFC3339Nano), len(chunk))
 		}
 
 		fmt.Fprintf(w, `
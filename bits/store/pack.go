@@ -0,0 +1,721 @@
+package bitsstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+
+	"github.com/boltdb/bolt"
+)
+
+//S3PackPrefix is the path below the prefix of a S3 remote under which a
+//pack remote stores its packs
+const S3PackPrefix = "bits-packs"
+
+const (
+	//DefaultPackSize is the number of bytes at which a pack is uploaded
+	DefaultPackSize = 32 * 1024 * 1024
+
+	//DefaultPackSealDelay is how long a pack remote waits for another
+	//chunk before a pack that is smaller than its size is uploaded
+	DefaultPackSealDelay = 100 * time.Millisecond
+
+	//DefaultPackSecretName is the name of the secret that the index of
+	//each pack is encrypted with when a store doesn't specify one
+	DefaultPackSecretName = "pack_secret"
+
+	//PackVersion is the version of the format packs are written in
+	PackVersion = 1
+)
+
+//PackMagic ends each pack, it is preceded by the version and the length of
+//the encrypted index
+var PackMagic = []byte("BITSPACK")
+
+var (
+	//BoltPackKeysBucket is the name of the bucket that holds the pack,
+	//offset and length of each key
+	BoltPackKeysBucket = []byte("pack_keys")
+
+	//BoltPacksBucket is the name of the bucket that holds the id of each
+	//pack whose keys are in the index
+	BoltPacksBucket = []byte("packs")
+)
+
+//length of the trailer that follows the encrypted index of a pack, and of
+//each entry of the index
+const (
+	packTrailerSize = 4 + 1 + 8
+	packEntrySize   = bits.KeySize + 8 + 4
+)
+
+//PackStoreConfig configures how a pack remote packs chunks, the packs are
+//stored in the S3 remote that the s3 settings describe
+type PackStoreConfig struct {
+	IndexPath  string `json:"pack_index_path,omitempty"`
+	SizeMiB    int64  `json:"pack_size_mib,omitempty"`
+	SecretName string `json:"pack_secret_name,omitempty"`
+}
+
+//Validate the pack remote configuration
+func (conf PackStoreConfig) Validate() error {
+	if conf.IndexPath == "" {
+		return &ValidationError{"pack", "pack_index_path", "a path to the database file of the pack index is required"}
+	}
+
+	if conf.SizeMiB != 0 && (conf.SizeMiB < 16 || conf.SizeMiB > 64) {
+		return &ValidationError{"pack", "pack_size_mib", fmt.Sprintf("packs must be between 16 and 64 MiB, got %d", conf.SizeMiB)}
+	}
+
+	return nil
+}
+
+//CreatePackRemote is the store factory for the 'pack' kind, the index of
+//each pack is encrypted with the secret that is configured by name
+func CreatePackRemote(settings StoreSettings) (s bits.Store, err error) {
+	remote, err := CreateS3Remote(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := PackStoreConfig{}
+	err = settings.Decode(&conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode pack store settings: %v", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	name := conf.SecretName
+	if name == "" {
+		name = DefaultPackSecretName
+	}
+
+	secret, ok := settings.Secret(name)
+	if !ok {
+		return nil, &ValidationError{"pack", "pack_secret_name", fmt.Sprintf("secret '%s' that encrypts the index of each pack is not configured", name)}
+	}
+
+	aead, err := NewPackAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(conf.IndexPath, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack index: %v", err)
+	}
+
+	size := int64(DefaultPackSize)
+	if conf.SizeMiB > 0 {
+		size = conf.SizeMiB * 1024 * 1024
+	}
+
+	r, err := NewPackRemote(remote.(*S3Remote), db, aead, size)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//NewPackAEAD derives the cipher that encrypts the index of each pack from
+//secret 's'
+func NewPackAEAD(s string) (aead cipher.AEAD, err error) {
+	mac := hmac.New(sha256.New, []byte(s))
+	mac.Write([]byte("bits pack index"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES block cipher: %v", err)
+	}
+
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup GCM cipher mode: %v", err)
+	}
+
+	return aead, nil
+}
+
+//packEntry locates a chunk in a pack
+type packEntry struct {
+	k      bits.K
+	offset uint64
+	length uint32
+}
+
+//badPackError is returned when a pack was fetched but cannot be read, as
+//opposed to a failed request
+type badPackError struct {
+	error
+}
+
+//pack collects chunks until it is uploaded, puts of its chunks wait until
+//it is done
+type pack struct {
+	buf     *bytes.Buffer
+	entries []packEntry
+	timer   *time.Timer
+	done    chan struct{}
+	err     error
+}
+
+//PackRemote bundles chunks into pack objects of a S3 remote, such that
+//a move doesn't cost a request per chunk. A put adds the chunk to the
+//current pack and returns once the pack was uploaded, which happens when
+//it holds Size bytes or no other chunk was added for SealDelay. Packs end
+//with an encrypted index of their chunks, a local bolt database indexes
+//the pack, offset and length of each key such that a chunk is fetched
+//with a single range request. The local index is completed from the
+//trailing index of packs it doesn't know when the remote is indexed, or
+//when a chunk is missing the first time, packs that cannot be read are
+//skipped until the next sync. Chunks cannot be deleted from a pack
+type PackRemote struct {
+	remote *S3Remote
+	db     *bolt.DB
+	aead   cipher.AEAD
+
+	mu      sync.Mutex
+	current *pack
+	pending map[bits.K]*pack
+
+	syncMu sync.Mutex
+	synced bool
+	bad    map[bits.K]error
+
+	//number of bytes at which a pack is uploaded
+	Size int64
+
+	//how long to wait for another chunk before a smaller pack is uploaded
+	SealDelay time.Duration
+}
+
+//NewPackRemote creates a remote that stores packs of at least 'size' bytes
+//in 'remote', their keys are indexed in database 'db' and the index of
+//each pack is encrypted with 'aead'
+func NewPackRemote(remote *S3Remote, db *bolt.DB, aead cipher.AEAD, size int64) (r *PackRemote, err error) {
+	r = &PackRemote{
+		remote:    remote,
+		db:        db,
+		aead:      aead,
+		pending:   map[bits.K]*pack{},
+		Size:      size,
+		SealDelay: DefaultPackSealDelay,
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(BoltPackKeysBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(BoltPacksBucket)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pack index buckets: %v", err)
+	}
+
+	return r, nil
+}
+
+func (r *PackRemote) packPrefix() string {
+	if r.remote.prefix == "" {
+		return S3PackPrefix
+	}
+
+	return fmt.Sprintf("%s/%s", r.remote.prefix, S3PackPrefix)
+}
+
+func (r *PackRemote) rawPackURL(id bits.K) string {
+	return fmt.Sprintf("%s/%s/%s", r.remote.rawBucketURL(), r.packPrefix(), id)
+}
+
+//Latency returns that the packs are reached over the network
+func (r *PackRemote) Latency() bits.Latency {
+	return bits.LatencyNetwork
+}
+
+//Put adds chunk 'k' to the current pack and waits until it was uploaded,
+//it does nothing if the index holds the key already
+func (r *PackRemote) Put(k bits.K, chunk []byte) error {
+	return r.PutContext(context.Background(), k, chunk)
+}
+
+//PutContext is like Put but stops waiting for the upload when the context
+//is done, the pack is uploaded regardless as other puts may wait for it
+func (r *PackRemote) PutContext(ctx context.Context, k bits.K, chunk []byte) error {
+	ok, err := r.Has(k)
+	if err != nil {
+		return err
+	} else if ok {
+		return nil
+	}
+
+	r.mu.Lock()
+	p, ok := r.pending[k]
+	if !ok {
+		p = r.current
+		if p == nil {
+			p = &pack{buf: bytes.NewBuffer(nil), done: make(chan struct{})}
+			r.current = p
+		}
+
+		p.entries = append(p.entries, packEntry{k: k, offset: uint64(p.buf.Len()), length: uint32(len(chunk))})
+		p.buf.Write(chunk)
+		r.pending[k] = p
+		if int64(p.buf.Len()) >= r.Size {
+			r.seal(p)
+		} else if p.timer == nil {
+			p.timer = time.AfterFunc(r.SealDelay, func() {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				if r.current == p {
+					r.seal(p)
+				}
+			})
+		} else {
+			p.timer.Reset(r.SealDelay)
+		}
+	}
+
+	r.mu.Unlock()
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//seal appends the encrypted index to pack 'p' and starts its upload, it
+//must be called while holding the lock
+func (r *PackRemote) seal(p *pack) {
+	r.current = nil
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+
+	index := make([]byte, 0, len(p.entries)*packEntrySize)
+	for _, e := range p.entries {
+		index = append(index, e.k[:]...)
+		index = append(index, make([]byte, 12)...)
+		binary.BigEndian.PutUint64(index[len(index)-12:], e.offset)
+		binary.BigEndian.PutUint32(index[len(index)-4:], e.length)
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		go r.finish(p, fmt.Errorf("failed to generate nonce: %v", err))
+		return
+	}
+
+	sealed := r.aead.Seal(nonce, nonce, index, PackMagic)
+	trailer := make([]byte, packTrailerSize)
+	binary.BigEndian.PutUint32(trailer, uint32(len(sealed)))
+	trailer[4] = PackVersion
+	copy(trailer[5:], PackMagic)
+	p.buf.Write(sealed)
+	p.buf.Write(trailer)
+	go r.upload(p)
+}
+
+//upload pack 'p' and record its chunks in the index
+func (r *PackRemote) upload(p *pack) {
+	body := p.buf.Bytes()
+	id := bits.K(sha256.Sum256(body))
+	_, _, err := r.remote.do(context.Background(), "PUT", r.rawPackURL(id), body, nil, http.StatusOK)
+	if err != nil {
+		r.finish(p, err)
+		return
+	}
+
+	r.finish(p, r.record(id, p.entries))
+}
+
+//finish marks pack 'p' as done, puts of its chunks return 'err'
+func (r *PackRemote) finish(p *pack, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		p.err = fmt.Errorf("failed to upload pack: %w", err)
+	}
+
+	for _, e := range p.entries {
+		delete(r.pending, e.k)
+	}
+
+	close(p.done)
+}
+
+//Close uploads the pack that is being filled, waits until every pack that
+//is being uploaded is done and then closes the database of the index
+func (r *PackRemote) Close() error {
+	r.mu.Lock()
+	if r.current != nil {
+		r.seal(r.current)
+	}
+
+	packs := map[*pack]struct{}{}
+	for _, p := range r.pending {
+		packs[p] = struct{}{}
+	}
+
+	r.mu.Unlock()
+	for p := range packs {
+		<-p.done
+	}
+
+	return r.db.Close()
+}
+
+//record the location of each chunk of pack 'id' in the index
+func (r *PackRemote) record(id bits.K, entries []packEntry) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(BoltPackKeysBucket)
+		packs := tx.Bucket(BoltPacksBucket)
+		if keys == nil || packs == nil {
+			return fmt.Errorf("pack index buckets must first be created")
+		}
+
+		for _, e := range entries {
+			loc := make([]byte, packEntrySize)
+			copy(loc, id[:])
+			binary.BigEndian.PutUint64(loc[bits.KeySize:], e.offset)
+			binary.BigEndian.PutUint32(loc[bits.KeySize+8:], e.length)
+			err := keys.Put(e.k[:], loc)
+			if err != nil {
+				return err
+			}
+		}
+
+		return packs.Put(id[:], []byte{})
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to index pack '%s': %v", id, err)
+	}
+
+	return nil
+}
+
+//BadPacks returns why each pack that the last sync skipped couldn't be
+//read, their chunks are not in the index
+func (r *PackRemote) BadPacks() (reasons map[bits.K]error) {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+	reasons = map[bits.K]error{}
+	for id, err := range r.bad {
+		reasons[id] = err
+	}
+
+	return reasons
+}
+
+//locate returns the pack of chunk 'k' and where it is in the pack
+func (r *PackRemote) locate(k bits.K) (id bits.K, e packEntry, ok bool, err error) {
+	err = r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltPackKeysBucket)
+		if b == nil {
+			return fmt.Errorf("pack keys bucket '%s' must first be created", string(BoltPackKeysBucket))
+		}
+
+		loc := b.Get(k[:])
+		if len(loc) != packEntrySize {
+			return nil
+		}
+
+		copy(id[:], loc)
+		e = packEntry{k: k, offset: binary.BigEndian.Uint64(loc[bits.KeySize:]), length: binary.BigEndian.Uint32(loc[bits.KeySize+8:])}
+		ok = true
+		return nil
+	})
+
+	return id, e, ok, err
+}
+
+//Has returns whether the index holds key 'k', chunks of packs that are
+//not yet uploaded are not considered
+func (r *PackRemote) Has(k bits.K) (ok bool, err error) {
+	_, _, ok, err = r.locate(k)
+	return ok, err
+}
+
+//Get chunk 'k' with a range request for its bytes in the pack, returns
+//an os.ErrNotExist if neither the index nor a pack that isn't uploaded
+//yet holds it
+func (r *PackRemote) Get(k bits.K) (chunk []byte, err error) {
+	return r.GetContext(context.Background(), k)
+}
+
+//GetContext is like Get but aborts the request when the context is done
+func (r *PackRemote) GetContext(ctx context.Context, k bits.K) (chunk []byte, err error) {
+	r.mu.Lock()
+	if p, ok := r.pending[k]; ok {
+		for _, e := range p.entries {
+			if e.k == k {
+				chunk = append(chunk, p.buf.Bytes()[e.offset:e.offset+uint64(e.length)]...)
+			}
+		}
+
+		r.mu.Unlock()
+		return chunk, nil
+	}
+
+	r.mu.Unlock()
+	id, e, ok, err := r.locate(k)
+	if err == nil && !ok {
+		err = r.syncOnce(ctx)
+		if err == nil {
+			id, e, ok, err = r.locate(k)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok || e.length == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	end := e.offset + uint64(e.length)
+	data, partial, err := r.getRange(ctx, id, fmt.Sprintf("bytes=%d-%d", e.offset, end-1))
+	if err != nil {
+		return nil, err
+	}
+
+	if !partial {
+		if uint64(len(data)) < end {
+			return nil, fmt.Errorf("pack '%s' is too short for chunk '%s'", id, k)
+		}
+
+		data = data[e.offset:end]
+	}
+
+	if len(data) != int(e.length) {
+		return nil, fmt.Errorf("expected %d bytes of chunk '%s' from pack '%s', got %d", e.length, k, id, len(data))
+	}
+
+	return data, nil
+}
+
+//getRange requests range 'rng' of pack 'id', the whole pack is returned
+//if the server doesn't support range requests
+func (r *PackRemote) getRange(ctx context.Context, id bits.K, rng string) (data []byte, partial bool, err error) {
+	header := http.Header{}
+	header.Set("Range", rng)
+	resp, data, err := r.remote.do(ctx, "GET", r.rawPackURL(id), nil, header, http.StatusPartialContent, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return data, true, nil
+	case http.StatusNotFound:
+		return nil, false, os.ErrNotExist
+	default:
+		return data, false, nil
+	}
+}
+
+//readIndex reads the trailing index of pack 'id', the tail of the pack is
+//requested such that a small index takes a single request. A pack that
+//cannot be read returns a badPackError
+func (r *PackRemote) readIndex(ctx context.Context, id bits.K) (entries []packEntry, err error) {
+	tail, partial, err := r.getRange(ctx, id, "bytes=-65536")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tail) < packTrailerSize || !bytes.HasSuffix(tail, PackMagic) {
+		return nil, badPackError{fmt.Errorf("pack '%s' doesn't end with the pack trailer", id)}
+	}
+
+	trailer := tail[len(tail)-packTrailerSize:]
+	if v := trailer[4]; v != PackVersion {
+		return nil, badPackError{fmt.Errorf("pack '%s' has version %d, only version %d is supported", id, v, PackVersion)}
+	}
+
+	n := int(binary.BigEndian.Uint32(trailer))
+	if n+packTrailerSize > len(tail) && partial {
+		tail, _, err = r.getRange(ctx, id, fmt.Sprintf("bytes=-%d", n+packTrailerSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if n+packTrailerSize > len(tail) || n < r.aead.NonceSize() {
+		return nil, badPackError{fmt.Errorf("pack '%s' has an index of invalid length %d", id, n)}
+	}
+
+	sealed := tail[len(tail)-packTrailerSize-n : len(tail)-packTrailerSize]
+	index, err := r.aead.Open(nil, sealed[:r.aead.NonceSize()], sealed[r.aead.NonceSize():], PackMagic)
+	if err != nil {
+		return nil, badPackError{fmt.Errorf("failed to decrypt index of pack '%s', please check the pack secret: %v", id, err)}
+	}
+
+	if len(index)%packEntrySize != 0 {
+		return nil, badPackError{fmt.Errorf("index of pack '%s' ends with a partial entry", id)}
+	}
+
+	for i := 0; i < len(index); i += packEntrySize {
+		e := packEntry{offset: binary.BigEndian.Uint64(index[i+bits.KeySize:]), length: binary.BigEndian.Uint32(index[i+bits.KeySize+8:])}
+		copy(e.k[:], index[i:])
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+//syncOnce syncs the index unless it was synced before by this remote
+func (r *PackRemote) syncOnce(ctx context.Context) error {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+	if r.synced {
+		return nil
+	}
+
+	return r.sync(ctx)
+}
+
+//Sync lists the packs of the remote and adds the chunks of each pack that
+//the index doesn't know to the index, as read from its trailing index.
+//Packs whose index cannot be read are skipped and reported by BadPacks,
+//such that one bad pack doesn't keep the others from being indexed. They
+//are read again by the next sync
+func (r *PackRemote) Sync(ctx context.Context) (err error) {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+	return r.sync(ctx)
+}
+
+//sync the index, it must be called while holding the sync lock
+func (r *PackRemote) sync(ctx context.Context) (err error) {
+	ids := []bits.K{}
+	err = r.remote.listObjects(ctx, r.packPrefix(), func(name string, size int64, mtime time.Time) error {
+		id, err := bits.DecodeKey([]byte(name))
+		if err != nil {
+			return nil
+		}
+
+		ids = append(ids, id)
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to list packs: %w", err)
+	}
+
+	bad := map[bits.K]error{}
+	defer func() { r.bad = bad }()
+	for _, id := range ids {
+		known := false
+		err = r.db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(BoltPacksBucket)
+			if b == nil {
+				return fmt.Errorf("packs bucket '%s' must first be created", string(BoltPacksBucket))
+			}
+
+			known = b.Get(id[:]) != nil
+			return nil
+		})
+
+		if err != nil {
+			return err
+		} else if known {
+			continue
+		}
+
+		//packs that were removed since listing are skipped as well
+		entries, err := r.readIndex(ctx, id)
+		if bperr := (badPackError{}); errors.As(err, &bperr) {
+			bad[id] = bperr.error
+			continue
+		} else if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = r.record(id, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.synced = true
+	return nil
+}
+
+//Index syncs the index with the packs of the remote and writes each key
+//it holds to key writer 'kw'
+func (r *PackRemote) Index(kw bits.KeyWriter) (err error) {
+	return r.IndexContext(context.Background(), kw)
+}
+
+//IndexContext is like Index but aborts syncing when the context is done
+func (r *PackRemote) IndexContext(ctx context.Context, kw bits.KeyWriter) (err error) {
+	err = r.Sync(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltPackKeysBucket)
+		if b == nil {
+			return fmt.Errorf("pack keys bucket '%s' must first be created", string(BoltPackKeysBucket))
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var key bits.K
+			copy(key[:], k)
+			err := kw.Write(key)
+			if err != nil {
+				return fmt.Errorf("index key handler failed: %v", err)
+			}
+
+			return nil
+		})
+	})
+}
+
+//PutMeta stores value 'v' under 'name' in the S3 remote
+func (r *PackRemote) PutMeta(name string, v []byte) error {
+	return r.remote.PutMeta(name, v)
+}
+
+//PutMetaContext is like PutMeta but aborts the upload when the context is done
+func (r *PackRemote) PutMetaContext(ctx context.Context, name string, v []byte) error {
+	return r.remote.PutMetaContext(ctx, name, v)
+}
+
+//GetMeta returns the value stored under 'name' in the S3 remote
+func (r *PackRemote) GetMeta(name string) (v []byte, err error) {
+	return r.remote.GetMeta(name)
+}
+
+//GetMetaContext is like GetMeta but aborts the download when the context is done
+func (r *PackRemote) GetMetaContext(ctx context.Context, name string) (v []byte, err error) {
+	return r.remote.GetMetaContext(ctx, name)
+}
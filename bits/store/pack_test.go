@@ -0,0 +1,219 @@
+package bitsstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+
+	"github.com/boltdb/bolt"
+)
+
+//rangeRecorder records the range header of each request it serves
+type rangeRecorder struct {
+	http.Handler
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (rr *rangeRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.mu.Lock()
+	rr.ranges = append(rr.ranges, r.Header.Get("Range"))
+	rr.mu.Unlock()
+	rr.Handler.ServeHTTP(w, r)
+}
+
+func newPackRemote(t *testing.T, dir, name, host, secret string) *bitsstore.PackRemote {
+	db, err := bolt.Open(filepath.Join(dir, name), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("failed to open pack index: %v", err)
+	}
+
+	aead, err := bitsstore.NewPackAEAD(secret)
+	if err != nil {
+		t.Fatalf("failed to create pack cipher: %v", err)
+	}
+
+	r, err := bitsstore.NewPackRemote(bitsstore.NewS3Remote("http", host, "", "", ""), db, aead, 64*1024)
+	if err != nil {
+		t.Fatalf("failed to create pack remote: %v", err)
+	}
+
+	r.SealDelay = 50 * time.Millisecond
+	return r
+}
+
+func TestPackRemote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits_pack_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	mem := bitsstore.NewMemStore()
+	rr := &rangeRecorder{Handler: mem}
+	svr := httptest.NewServer(rr)
+	defer svr.Close()
+
+	host := strings.TrimPrefix(svr.URL, "http://")
+	remote := newPackRemote(t, dir, "a.bolt", host, "my-secret")
+	chunks := map[bits.K][]byte{}
+	for i := 0; i < 20; i++ {
+		chunk := randb(10 * 1024)
+		chunks[bits.K(sha256.Sum256(chunk))] = chunk
+	}
+
+	wg := sync.WaitGroup{}
+	for k, chunk := range chunks {
+		wg.Add(1)
+		go func(k bits.K, chunk []byte) {
+			defer wg.Done()
+			if err := remote.Put(k, chunk); err != nil {
+				t.Errorf("failed to put chunk '%s': %v", k, err)
+			}
+		}(k, chunk)
+	}
+
+	wg.Wait()
+	if len(mem.Chunks) != 0 || len(mem.Objects) < 3 || len(mem.Objects) > 10 {
+		t.Errorf("expected 200KiB of chunks in a few packs of at most 64KiB, got %d chunks and %d packs", len(mem.Chunks), len(mem.Objects))
+	}
+
+	rr.ranges = nil
+	for k, chunk := range chunks {
+		output, err := remote.Get(k)
+		if err != nil || !bytes.Equal(output, chunk) {
+			t.Fatalf("expected chunk '%s' to be returned from its pack, got: %v", k, err)
+		}
+
+		if ok, err := remote.Has(k); !ok || err != nil {
+			t.Errorf("expected remote to have chunk '%s', got: %v, %v", k, ok, err)
+		}
+	}
+
+	if len(rr.ranges) != len(chunks) || !strings.HasPrefix(rr.ranges[0], "bytes=") {
+		t.Errorf("expected a single range request per chunk, got: %v", rr.ranges)
+	}
+
+	if _, err = remote.Get(bits.K{}); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for unknown key, got: %v", err)
+	}
+
+	//a remote with an empty index reads the index that trails each pack
+	other := newPackRemote(t, dir, "b.bolt", host, "my-secret")
+	for k, chunk := range chunks {
+		output, err := other.Get(k)
+		if err != nil || !bytes.Equal(output, chunk) {
+			t.Fatalf("expected chunk '%s' to be found after syncing, got: %v", k, err)
+		}
+	}
+
+	iter := bitskeys.NewMemIterator()
+	if err = other.Index(iter); err != nil || len(iter.Keys) != len(chunks) {
+		t.Errorf("expected %d keys to be indexed, got %d: %v", len(chunks), len(iter.Keys), err)
+	}
+
+	//puts of chunks that are indexed don't create packs
+	n := len(mem.Objects)
+	for k, chunk := range chunks {
+		if err = other.Put(k, chunk); err != nil {
+			t.Fatalf("failed to put chunk '%s': %v", k, err)
+		}
+	}
+
+	if len(mem.Objects) != n {
+		t.Errorf("expected no new packs, got %d instead of %d", len(mem.Objects), n)
+	}
+
+	wrong := newPackRemote(t, dir, "c.bolt", host, "other-secret")
+	err = wrong.Sync(context.Background())
+	for id, reason := range wrong.BadPacks() {
+		if !strings.Contains(reason.Error(), "failed to decrypt index") {
+			t.Errorf("expected index of pack '%s' to be unreadable with another secret, got: %v", id, reason)
+		}
+	}
+
+	if bad := wrong.BadPacks(); err != nil || len(bad) != n {
+		t.Errorf("expected all %d packs to be skipped with another secret, got %d (%v)", n, len(bad), err)
+	}
+
+	//a corrupt pack is skipped, the chunks of other packs are indexed
+	var corrupt bits.K
+	mem.Lock()
+	for name := range mem.Objects {
+		mem.Objects[name[:strings.LastIndex(name, "/")+1]+corrupt.String()] = []byte("not a pack")
+		break
+	}
+	mem.Unlock()
+
+	iter = bitskeys.NewMemIterator()
+	fresh := newPackRemote(t, dir, "d.bolt", host, "my-secret")
+	if err = fresh.Index(iter); err != nil || len(iter.Keys) != len(chunks) {
+		t.Errorf("expected %d keys to be indexed past a corrupt pack, got %d: %v", len(chunks), len(iter.Keys), err)
+	}
+
+	if reason := fresh.BadPacks()[corrupt]; len(fresh.BadPacks()) != 1 || reason == nil || !strings.Contains(reason.Error(), "pack trailer") {
+		t.Errorf("expected only the corrupt pack to be reported as bad, got: %v", fresh.BadPacks())
+	}
+}
+
+func TestPackRemoteClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bits_pack_")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	defer os.RemoveAll(dir)
+	mem := bitsstore.NewMemStore()
+	svr := httptest.NewServer(mem)
+	defer svr.Close()
+
+	settings := &jsonSettings{
+		data:    fmt.Sprintf(`{"s3_scheme": "http", "s3_host": "%s", "pack_index_path": "%s"}`, strings.TrimPrefix(svr.URL, "http://"), filepath.Join(dir, "packs.bolt")),
+		secrets: map[string]string{"pack_secret": "my-secret"},
+	}
+
+	first, err := bitsstore.CreateStore("pack", settings)
+	if err != nil {
+		t.Fatalf("failed to create pack remote: %v", err)
+	}
+
+	//a put that stops waiting leaves its chunk in the pack that is filled
+	chunk := randb(1024)
+	k := bits.K(sha256.Sum256(chunk))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = first.(*bitsstore.PackRemote).PutContext(ctx, k, chunk); err != context.Canceled {
+		t.Fatalf("expected put to stop waiting, got: %v", err)
+	}
+
+	if err = first.(io.Closer).Close(); err != nil || len(mem.Objects) != 1 {
+		t.Fatalf("expected close to upload the pack, got %d packs (%v)", len(mem.Objects), err)
+	}
+
+	//the index is released, it can be opened again right away
+	start := time.Now()
+	second, err := bitsstore.CreateStore("pack", settings)
+	if err != nil || time.Since(start) > time.Second {
+		t.Fatalf("expected the index to be opened again without waiting, took %s (%v)", time.Since(start), err)
+	}
+
+	defer second.(io.Closer).Close()
+	if ok, err := second.(*bitsstore.PackRemote).Has(k); !ok || err != nil {
+		t.Errorf("expected chunk of the uploaded pack to be indexed, got: %v, %v", ok, err)
+	}
+}
@@ -2,20 +2,133 @@ package bitsstore
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/advanderveer/libchunk/bits"
 
 	"github.com/smartystreets/go-aws-auth"
 )
 
-//S3Remote will put and get chunks from an AWS S3 (compatible) interface
+//DefaultS3SecretKeyName is the name of the secret that holds the S3 secret
+//access key when a store doesn't specify one explicitely
+const DefaultS3SecretKeyName = "s3_secret_key"
+
+//S3MetaPrefix is the path below the prefix of a S3 remote under which
+//named values about the store itself are kept as objects
+const S3MetaPrefix = "bits-meta"
+
+const (
+	//DefaultS3MaxRetries is the number of times a S3 remote tries a request
+	//again when it failed transiently
+	DefaultS3MaxRetries = 4
+
+	//DefaultS3RetryBaseDelay is the delay before the first retry
+	DefaultS3RetryBaseDelay = 100 * time.Millisecond
+
+	//DefaultS3RetryMaxDelay caps the delay between retries
+	DefaultS3RetryMaxDelay = 10 * time.Second
+)
+
+//S3StoreConfig configures a S3 remote, the secret access key is not part of
+//it as it is expected to be stored encrypted separately
+type S3StoreConfig struct {
+	Scheme        string `json:"s3_scheme,omitempty"`
+	Host          string `json:"s3_host,omitempty"`
+	Prefix        string `json:"s3_prefix,omitempty"`
+	AccessKey     string `json:"s3_access_key,omitempty"`
+	SecretKeyName string `json:"s3_secret_key_name,omitempty"`
+	MaxRetries    int    `json:"s3_max_retries,omitempty"`
+}
+
+//Validate the s3 remote configuration
+func (conf S3StoreConfig) Validate() error {
+	if conf.Host == "" {
+		return &ValidationError{"s3", "s3_host", "a hostname is required"}
+	}
+
+	if conf.Scheme != "" && conf.Scheme != "http" && conf.Scheme != "https" {
+		return &ValidationError{"s3", "s3_scheme", fmt.Sprintf("scheme must be 'http' or 'https', got '%s'", conf.Scheme)}
+	}
+
+	if strings.Contains(conf.Host, "/") {
+		return &ValidationError{"s3", "s3_host", fmt.Sprintf("host '%s' must not contain a path or scheme", conf.Host)}
+	}
+
+	if conf.MaxRetries < -1 {
+		return &ValidationError{"s3", "s3_max_retries", fmt.Sprintf("must be -1 to disable retries, zero for the default of %d or positive, got %d", DefaultS3MaxRetries, conf.MaxRetries)}
+	}
+
+	return nil
+}
+
+//CreateS3Remote is the store factory for the 's3' kind, the secret access
+//key is looked up in the secrets when an access key is configured
+func CreateS3Remote(settings StoreSettings) (s bits.Store, err error) {
+	conf := S3StoreConfig{}
+	err = settings.Decode(&conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode s3 store settings: %v", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	secretKey := ""
+	if conf.AccessKey != "" {
+		name := conf.SecretKeyName
+		if name == "" {
+			name = DefaultS3SecretKeyName
+		}
+
+		var ok bool
+		secretKey, ok = settings.Secret(name)
+		if !ok {
+			return nil, &ValidationError{"s3", "s3_secret_key_name", fmt.Sprintf("an access key is configured but secret '%s' is not", name)}
+		}
+	}
+
+	r := NewS3Remote(conf.Scheme, conf.Host, conf.Prefix, conf.AccessKey, secretKey)
+	if conf.MaxRetries != 0 {
+		r.MaxRetries = conf.MaxRetries
+		if r.MaxRetries < 0 {
+			r.MaxRetries = 0
+		}
+	}
+
+	return r, nil
+}
+
+//S3Remote will put and get chunks from an AWS S3 (compatible) interface.
+//Requests that fail transiently, e.g: on a dropped connection or a 503, are
+//tried again with an exponentially growing delay
 type S3Remote struct {
+	//MaxRetries is the number of times a request that failed transiently is
+	//tried again, it is never tried again if zero
+	MaxRetries int
+
+	//RetryBaseDelay is the delay before the first retry, it doubles with each
+	//retry up to RetryMaxDelay. A random part of each delay is skipped such
+	//that clients that failed together don't all retry at the same time
+	RetryBaseDelay time.Duration
+
+	//RetryMaxDelay caps the delay between retries, a request is not tried
+	//again if the server asks to wait longer than this with Retry-After
+	RetryMaxDelay time.Duration
+
 	scheme string
 	host   string
 	prefix string
@@ -37,13 +150,200 @@ func NewS3Remote(scheme, host, prefix, accessKey, secretKey string) *S3Remote {
 			AccessKeyID:     accessKey,
 			SecretAccessKey: secretKey,
 		},
-		client: &http.Client{},
-		scheme: scheme,
-		host:   host,
-		prefix: prefix,
+		client:         &http.Client{},
+		scheme:         scheme,
+		host:           host,
+		prefix:         prefix,
+		MaxRetries:     DefaultS3MaxRetries,
+		RetryBaseDelay: DefaultS3RetryBaseDelay,
+		RetryMaxDelay:  DefaultS3RetryMaxDelay,
+	}
+}
+
+//S3Error is returned when a request to a S3 remote failed, either because
+//no response was received or because the response had an unexpected status
+type S3Error struct {
+	Method     string
+	URL        string
+	StatusCode int    //zero if no response was received
+	Status     string //status line of the response
+	Body       string //body of the response
+	Attempts   int    //number of times the request was tried
+	Err        error  //the reason no response was received
+}
+
+func (e *S3Error) Error() string {
+	msg := ""
+	if e.StatusCode == 0 {
+		msg = fmt.Sprintf("failed to perform %s '%s' request: %v", e.Method, e.URL, e.Err)
+	} else {
+		msg = fmt.Sprintf("unexpected response from %s '%s' request: %s, body: %v", e.Method, e.URL, e.Status, e.Body)
+	}
+
+	if e.Attempts > 1 {
+		msg = fmt.Sprintf("%s (tried %d times)", msg, e.Attempts)
+	}
+
+	return msg
+}
+
+//Unwrap returns the reason no response was received, if any
+func (e *S3Error) Unwrap() error {
+	return e.Err
+}
+
+//Transient returns whether the request may succeed when it is tried again:
+//when no response was received, unless the context was done, or when the
+//server was overloaded or failed internally
+func (e *S3Error) Transient() bool {
+	switch e.StatusCode {
+	case 0:
+		return !errors.Is(e.Err, context.Canceled) && !errors.Is(e.Err, context.DeadlineExceeded)
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
 	}
 }
 
+//do performs a signed request and reads the whole response body, responses
+//with a status other than those 'expected' are returned as a S3Error.
+//Requests that failed transiently are tried again after a delay, or after
+//the delay that the server asks for with a Retry-After header
+func (r *S3Remote) do(ctx context.Context, method, raw string, body []byte, header http.Header, expected ...int) (resp *http.Response, data []byte, err error) {
+	req, err := http.NewRequest(method, raw, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s request: %v", method, err)
+	}
+
+	for name, vals := range header {
+		req.Header[name] = vals
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, data, s3err := r.try(ctx, req, body, expected)
+		if s3err == nil {
+			return resp, data, nil
+		}
+
+		s3err.Attempts = attempt
+		if attempt > r.MaxRetries || !s3err.Transient() {
+			return nil, nil, s3err
+		}
+
+		delay := r.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				if after > r.RetryMaxDelay {
+					return nil, nil, s3err
+				}
+
+				if after > delay {
+					delay = after
+				}
+			}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, s3err
+		}
+	}
+}
+
+//try performs request 'req' once with a fresh reader of 'body', the response
+//is returned along with the error if its status was unexpected
+func (r *S3Remote) try(ctx context.Context, req *http.Request, body []byte, expected []int) (resp *http.Response, data []byte, s3err *S3Error) {
+	//each attempt is signed anew, e.g: with the time of the attempt
+	method, raw := req.Method, req.URL.String()
+	req = req.WithContext(ctx)
+	req.Header = req.Header.Clone()
+	if len(body) > 0 {
+		req.ContentLength = int64(len(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+
+		req.Body, _ = req.GetBody()
+	}
+
+	if r.creds.AccessKeyID != "" {
+		awsauth.Sign(req, r.creds)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		return nil, nil, &S3Error{Method: method, URL: raw, Err: err}
+	}
+
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		return nil, nil, &S3Error{Method: method, URL: raw, Err: fmt.Errorf("failed to read response body for %s: %w", resp.Status, err)}
+	}
+
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, data, nil
+		}
+	}
+
+	return resp, nil, &S3Error{Method: method, URL: raw, StatusCode: resp.StatusCode, Status: resp.Status, Body: string(data)}
+}
+
+//backoff returns the delay before retry 'n', it doubles with each retry up
+//to the maximum delay and a random part of up to half is skipped
+func (r *S3Remote) backoff(n int) time.Duration {
+	d := r.RetryBaseDelay
+	for i := 1; i < n && d < r.RetryMaxDelay; i++ {
+		d *= 2
+	}
+
+	if d > r.RetryMaxDelay {
+		d = r.RetryMaxDelay
+	}
+
+	if d < 2 {
+		return d
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+//retryAfter parses the value of a Retry-After header, which is either a
+//number of seconds or a date
+func retryAfter(v string) (d time.Duration, ok bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Until(t), true
+}
+
 func (r *S3Remote) rawKeyURL(k bits.K) string {
 	if r.prefix == "" {
 		return fmt.Sprintf("%s://%s/%s", r.scheme, r.host, k)
@@ -52,19 +352,70 @@ func (r *S3Remote) rawKeyURL(k bits.K) string {
 	return fmt.Sprintf("%s://%s/%s/%s", r.scheme, r.host, r.prefix, k)
 }
 
+func (r *S3Remote) rawMetaURL(name string) string {
+	if r.prefix == "" {
+		return fmt.Sprintf("%s://%s/%s/%s", r.scheme, r.host, S3MetaPrefix, name)
+	}
+
+	return fmt.Sprintf("%s://%s/%s/%s/%s", r.scheme, r.host, r.prefix, S3MetaPrefix, name)
+}
+
 func (r *S3Remote) rawBucketURL() string {
 	return fmt.Sprintf("%s://%s", r.scheme, r.host)
 }
 
+//Latency returns that the remote is reached over the network
+func (r *S3Remote) Latency() bits.Latency {
+	return bits.LatencyNetwork
+}
+
 //Index will use the remoet list interface to fetch all keys in the bucket
 func (r *S3Remote) Index(kw bits.KeyWriter) (err error) {
+	return r.IndexContext(context.Background(), kw)
+}
+
+//IndexContext is like Index but aborts listing when the context is done
+func (r *S3Remote) IndexContext(ctx context.Context, kw bits.KeyWriter) (err error) {
+	return r.ListContext(ctx, func(info bits.ChunkInfo) error {
+		err := kw.Write(info.K)
+		if err != nil {
+			return fmt.Errorf("index key handler failed: %v", err)
+		}
+
+		return nil
+	})
+}
+
+//List calls 'fn' with each object in the bucket whose name is a key, its
+//ModTime is the time at which the object was last modified
+func (r *S3Remote) List(fn func(info bits.ChunkInfo) error) (err error) {
+	return r.ListContext(context.Background(), fn)
+}
+
+//ListContext is like List but aborts listing when the context is done
+func (r *S3Remote) ListContext(ctx context.Context, fn func(info bits.ChunkInfo) error) (err error) {
+	return r.listObjects(ctx, r.prefix, func(name string, size int64, mtime time.Time) error {
+		k, err := bits.DecodeKey([]byte(name))
+		if err != nil {
+			return nil
+		}
+
+		return fn(bits.ChunkInfo{K: k, Size: size, ModTime: mtime})
+	})
+}
+
+//listObjects calls 'fn' with each object whose name starts with 'prefix',
+//the name it is called with is relative to the prefix
+func (r *S3Remote) listObjects(ctx context.Context, prefix string, fn func(name string, size int64, mtime time.Time) error) (err error) {
 	v := struct {
 		XMLName               xml.Name `xml:"ListBucketResult"`
 		Name                  string   `xml:"Name"`
 		IsTruncated           bool     `xml:"IsTruncated"`
 		NextContinuationToken string   `xml:"NextContinuationToken"`
 		Contents              []struct {
-			Key string `xml:"Key"`
+			Key          string    `xml:"Key"`
+			LastModified time.Time `xml:"LastModified"`
+			Size         int64     `xml:"Size"`
 		} `xml:"Contents"`
 	}{}
 
@@ -73,8 +424,8 @@ func (r *S3Remote) Index(kw bits.KeyWriter) (err error) {
 		q := url.Values{}
 		q.Set("list-type", "2")
 		q.Set("max-keys", "500")
-		if r.prefix != "" {
-			q.Set("prefix", r.prefix)
+		if prefix != "" {
+			q.Set("prefix", prefix)
 		}
 
 		if next != "" {
@@ -87,38 +438,22 @@ func (r *S3Remote) Index(kw bits.KeyWriter) (err error) {
 			return fmt.Errorf("failed to parse '%s' as url: %v", raw, err)
 		}
 
-		req, err := http.NewRequest("GET", loc.String(), nil)
+		_, data, err := r.do(ctx, "GET", loc.String(), nil, nil, http.StatusOK)
 		if err != nil {
-			return fmt.Errorf("failed to create listing request: %v", err)
-		}
-
-		if r.creds.AccessKeyID != "" {
-			awsauth.Sign(req, r.creds)
+			return fmt.Errorf("failed to request bucket list: %w", err)
 		}
 
-		resp, err := r.client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to request bucket list: %v", err)
-		}
-
-		defer resp.Body.Close()
-		dec := xml.NewDecoder(resp.Body)
-		err = dec.Decode(&v)
+		err = xml.Unmarshal(data, &v)
 		if err != nil {
 			return fmt.Errorf("failed to decode s3 xml: %v", err)
 		}
 
 		for _, obj := range v.Contents {
-			str := strings.TrimPrefix(obj.Key, r.prefix)
+			str := strings.TrimPrefix(obj.Key, prefix)
 			str = strings.TrimLeft(str, "/")
-			k, err := bits.DecodeKey([]byte(str))
+			err = fn(str, obj.Size, obj.LastModified)
 			if err != nil {
-				continue
-			}
-
-			err = kw.Write(k)
-			if err != nil {
-				return fmt.Errorf("index key handler failed: %v", err)
+				return err
 			}
 		}
 
@@ -135,70 +470,100 @@ func (r *S3Remote) Index(kw bits.KeyWriter) (err error) {
 
 //Put uploads a chunk to an S3 object store under the provided key 'k'
 func (r *S3Remote) Put(k bits.K, chunk []byte) error {
+	return r.PutContext(context.Background(), k, chunk)
+}
+
+//PutContext is like Put but aborts the upload when the context is done
+func (r *S3Remote) PutContext(ctx context.Context, k bits.K, chunk []byte) error {
 	raw := r.rawKeyURL(k)
 	loc, err := url.Parse(raw)
 	if err != nil {
 		return fmt.Errorf("failed to parse '%s' as url: %v", raw, err)
 	}
 
-	req, err := http.NewRequest("PUT", loc.String(), bytes.NewBuffer(chunk))
-	if err != nil {
-		return fmt.Errorf("failed to create PUT request: %v", err)
-	}
-
-	if r.creds.AccessKeyID != "" {
-		awsauth.Sign(req, r.creds)
-	}
-
-	resp, err := r.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to perform PUT request: %v", err)
-	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read response body for unexpected response: %s", resp.Status)
-		}
-
-		return fmt.Errorf("unexpected response from PUT '%s' request: %s, body: %v", loc, resp.Status, string(body))
-	}
-
-	return nil
+	_, _, err = r.do(ctx, "PUT", loc.String(), chunk, nil, http.StatusOK)
+	return err
 }
 
-//Get attempts to download chunk 'k' from an S3 object store
+//Get attempts to download chunk 'k' from an S3 object store, returns an
+//os.ErrNotExist if the object doesn't exist
 func (r *S3Remote) Get(k bits.K) (chunk []byte, err error) {
+	return r.GetContext(context.Background(), k)
+}
+
+//GetContext is like Get but aborts the download when the context is done
+func (r *S3Remote) GetContext(ctx context.Context, k bits.K) (chunk []byte, err error) {
 	raw := r.rawKeyURL(k)
 	loc, err := url.Parse(raw)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse '%s' as url: %v", raw, err)
 	}
 
-	req, err := http.NewRequest("GET", loc.String(), bytes.NewBuffer(chunk))
+	resp, chunk, err := r.do(ctx, "GET", loc.String(), nil, nil, http.StatusOK, http.StatusNotFound)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create PUT request: %v", err)
+		return nil, err
 	}
 
-	if r.creds.AccessKeyID != "" {
-		awsauth.Sign(req, r.creds)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
 	}
 
-	resp, err := r.client.Do(req)
+	return chunk, nil
+}
+
+//Has checks whether an object exists under key 'k' with a HEAD request
+func (r *S3Remote) Has(k bits.K) (ok bool, err error) {
+	return r.HasContext(context.Background(), k)
+}
+
+//HasContext is like Has but aborts the request when the context is done
+func (r *S3Remote) HasContext(ctx context.Context, k bits.K) (ok bool, err error) {
+	resp, _, err := r.do(ctx, "HEAD", r.rawKeyURL(k), nil, nil, http.StatusOK, http.StatusNotFound)
 	if err != nil {
-		return nil, fmt.Errorf("failed to perform PUT request: %v", err)
+		return false, err
 	}
 
-	defer resp.Body.Close()
-	chunk, err = ioutil.ReadAll(resp.Body)
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+//Delete removes the object under key 'k' with a DELETE request
+func (r *S3Remote) Delete(k bits.K) error {
+	return r.DeleteContext(context.Background(), k)
+}
+
+//DeleteContext is like Delete but aborts the request when the context is done
+func (r *S3Remote) DeleteContext(ctx context.Context, k bits.K) error {
+	_, _, err := r.do(ctx, "DELETE", r.rawKeyURL(k), nil, nil, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+	return err
+}
+
+//PutMeta uploads value 'v' as an object under the meta prefix
+func (r *S3Remote) PutMeta(name string, v []byte) error {
+	return r.PutMetaContext(context.Background(), name, v)
+}
+
+//PutMetaContext is like PutMeta but aborts the upload when the context is done
+func (r *S3Remote) PutMetaContext(ctx context.Context, name string, v []byte) error {
+	_, _, err := r.do(ctx, "PUT", r.rawMetaURL(name), v, nil, http.StatusOK)
+	return err
+}
+
+//GetMeta downloads the value stored under 'name', returns an
+//os.ErrNotExist if the object doesn't exist
+func (r *S3Remote) GetMeta(name string) (v []byte, err error) {
+	return r.GetMetaContext(context.Background(), name)
+}
+
+//GetMetaContext is like GetMeta but aborts the download when the context is done
+func (r *S3Remote) GetMetaContext(ctx context.Context, name string) (v []byte, err error) {
+	resp, v, err := r.do(ctx, "GET", r.rawMetaURL(name), nil, nil, http.StatusOK, http.StatusNotFound)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body for %s: %v", resp.Status, err)
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected response from PUT '%s' request: %s, body: %v", loc, resp.Status, string(chunk))
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
 	}
 
-	return chunk, nil
+	return v, nil
 }
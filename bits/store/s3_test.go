@@ -2,11 +2,18 @@ package bitsstore_test
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -80,3 +87,150 @@ func TestActualS3PutGet(t *testing.T) {
 		t.Fatal("should return al least one key from indexing")
 	}
 }
+
+//flakyServer fails the first requests it serves, with a status or by
+//dropping the connection if the status is zero, before serving the rest
+type flakyServer struct {
+	http.Handler
+	mu         sync.Mutex
+	failures   int
+	status     int
+	retryAfter string
+	requests   int
+}
+
+func (fs *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	fs.requests++
+	fail := fs.requests <= fs.failures
+	fs.mu.Unlock()
+	if !fail {
+		fs.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	if fs.status == 0 {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+
+		return
+	}
+
+	if fs.retryAfter != "" {
+		w.Header().Set("Retry-After", fs.retryAfter)
+	}
+
+	w.WriteHeader(fs.status)
+}
+
+func TestS3RemoteRetries(t *testing.T) {
+	cases := []struct {
+		name       string
+		failures   int
+		status     int
+		retryAfter string
+		maxRetries int
+		requests   int
+		failed     bool
+		transient  bool
+		minElapsed time.Duration
+	}{
+		{"dropped_connections", 2, 0, "", 4, 3, false, false, 0},
+		{"slow_down", 2, http.StatusServiceUnavailable, "", 4, 3, false, false, 0},
+		{"too_many_requests_with_retry_after", 1, http.StatusTooManyRequests, "1", 4, 2, false, false, time.Second},
+		{"retry_after_beyond_max_delay", 1, http.StatusServiceUnavailable, "60", 4, 1, true, true, 0},
+		{"retries_exhausted", 10, http.StatusInternalServerError, "", 2, 3, true, true, 0},
+		{"retries_disabled", 1, http.StatusBadGateway, "", 0, 1, true, true, 0},
+		{"forbidden_is_permanent", 1, http.StatusForbidden, "", 4, 1, true, false, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fs := &flakyServer{Handler: bitsstore.NewMemStore(), failures: c.failures, status: c.status, retryAfter: c.retryAfter}
+			svr := httptest.NewServer(fs)
+			defer svr.Close()
+
+			remote := bitsstore.NewS3Remote("http", strings.TrimPrefix(svr.URL, "http://"), "", "", "")
+			remote.MaxRetries = c.maxRetries
+			remote.RetryBaseDelay = time.Millisecond
+			remote.RetryMaxDelay = 2 * time.Second
+
+			input := randb(1024)
+			k := bits.K(sha256.Sum256(input))
+			started := time.Now()
+			err := remote.Put(k, input)
+			if c.failed != (err != nil) {
+				t.Fatalf("expected put to fail: %v, got: %v", c.failed, err)
+			}
+
+			if err != nil && bits.IsTransient(err) != c.transient {
+				t.Errorf("expected error to be transient: %v, got: %v", c.transient, err)
+			}
+
+			if fs.requests != c.requests {
+				t.Errorf("expected %d requests, got: %d", c.requests, fs.requests)
+			}
+
+			if elapsed := time.Since(started); elapsed < c.minElapsed {
+				t.Errorf("expected put to wait at least %s, took: %s", c.minElapsed, elapsed)
+			}
+
+			if err != nil {
+				return
+			}
+
+			output, err := remote.Get(k)
+			if err != nil || !bytes.Equal(output, input) {
+				t.Errorf("expected chunk to be put, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestS3RemoteRetryAborts(t *testing.T) {
+	fs := &flakyServer{Handler: bitsstore.NewMemStore(), failures: 100, status: http.StatusServiceUnavailable}
+	svr := httptest.NewServer(fs)
+	defer svr.Close()
+
+	remote := bitsstore.NewS3Remote("http", strings.TrimPrefix(svr.URL, "http://"), "", "", "")
+	remote.RetryBaseDelay = time.Minute
+	remote.RetryMaxDelay = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := remote.GetContext(ctx, bits.K{})
+	if err == nil || !bits.IsTransient(err) {
+		t.Errorf("expected the last transient error, got: %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("expected the delay before retrying to be aborted, took: %s", elapsed)
+	}
+}
+
+func TestS3RemoteBodyReadAborts(t *testing.T) {
+	requests := int32(0)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Length", "1024")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+
+	defer svr.Close()
+	remote := bitsstore.NewS3Remote("http", strings.TrimPrefix(svr.URL, "http://"), "", "", "")
+	remote.RetryBaseDelay = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	//a body that is still being read when the deadline exceeds isn't retried
+	_, err := remote.GetContext(ctx, bits.K{})
+	if !errors.Is(err, context.DeadlineExceeded) || bits.IsTransient(err) || atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("expected a single request that failed on the deadline, got %d requests: %v", atomic.LoadInt32(&requests), err)
+	}
+}
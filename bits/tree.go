@@ -0,0 +1,270 @@
+package bits
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+const (
+	//TreeFanOut is the maximum number of keys that a node of a hash tree
+	//lists, a node of 32KiB describes 1GiB of 1MiB chunks
+	TreeFanOut = 1024
+
+	//maximum height of a tree, which is far more than any file needs
+	maxTreeLevel = 16
+)
+
+//TreeNodeMagic starts the plaintext of each node of a hash tree, it tells
+//nodes apart from the chunks of a file such that a root key can be checked
+var TreeNodeMagic = []byte("BITSTREE")
+
+//encodeTreeNode returns the plaintext of a node that lists 'keys'. Nodes at
+//level 0 list the chunks of the file, nodes at higher levels list nodes
+func encodeTreeNode(level int, keys []K) []byte {
+	node := make([]byte, 0, len(TreeNodeMagic)+1+len(keys)*KeySize)
+	node = append(node, TreeNodeMagic...)
+	node = append(node, byte(level))
+	for _, k := range keys {
+		node = append(node, k[:]...)
+	}
+
+	return node
+}
+
+//decodeTreeNode parses the plaintext of a node
+func decodeTreeNode(node []byte) (level int, keys []K, err error) {
+	if !bytes.HasPrefix(node, TreeNodeMagic) || len(node) < len(TreeNodeMagic)+1 {
+		return 0, nil, fmt.Errorf("chunk is not a node of a tree")
+	}
+
+	level = int(node[len(TreeNodeMagic)])
+	data := node[len(TreeNodeMagic)+1:]
+	if level >= maxTreeLevel || len(data)%KeySize != 0 || len(data)/KeySize > TreeFanOut {
+		return 0, nil, fmt.Errorf("node of a tree is malformed")
+	}
+
+	keys = make([]K, len(data)/KeySize)
+	for i := range keys {
+		copy(keys[i][:], data[i*KeySize:])
+	}
+
+	return level, keys, nil
+}
+
+//treeWriter is a key writer that groups the keys of a file into nodes and
+//stores each node as a chunk, the keys of the nodes are grouped again at
+//the next level until a single root node remains
+type treeWriter struct {
+	ctx    context.Context
+	conf   Config
+	dst    Store
+	levels [][]K
+}
+
+//putNode hashes, encrypts and stores a node, it returns the node's key
+func (tw *treeWriter) putNode(level int, keys []K) (k K, err error) {
+	node := encodeTreeNode(level, keys)
+	k = tw.conf.KeyHash(node)
+	sealed, err := sealChunk(tw.conf, k, node)
+	if err != nil {
+		return k, err
+	}
+
+	err = putContext(tw.ctx, tw.dst, k, sealed)
+	if err != nil {
+		return k, fmt.Errorf("failed to put node of tree: %v", err)
+	}
+
+	return k, nil
+}
+
+//add appends a key to a level and stores its node when it is full
+func (tw *treeWriter) add(level int, k K) error {
+	if level == len(tw.levels) {
+		if level >= maxTreeLevel {
+			return fmt.Errorf("tree exceeds the maximum height of %d levels", maxTreeLevel)
+		}
+
+		tw.levels = append(tw.levels, nil)
+	}
+
+	tw.levels[level] = append(tw.levels[level], k)
+	if len(tw.levels[level]) < TreeFanOut {
+		return nil
+	}
+
+	nk, err := tw.putNode(level, tw.levels[level])
+	if err != nil {
+		return err
+	}
+
+	tw.levels[level] = nil
+	return tw.add(level+1, nk)
+}
+
+//Write adds the key of the file's next chunk to the tree
+func (tw *treeWriter) Write(k K) error {
+	return tw.add(0, k)
+}
+
+//root stores the nodes that are not full and returns the root key
+func (tw *treeWriter) root() (k K, err error) {
+	if len(tw.levels) == 0 {
+		tw.levels = append(tw.levels, nil) //empty input
+	}
+
+	for level := 0; level < len(tw.levels); level++ {
+		keys := tw.levels[level]
+		top := level == len(tw.levels)-1
+		if !top && len(keys) == 0 {
+			continue
+		}
+
+		//the single full node below the top is the root already
+		if top && level > 0 && len(keys) == 1 {
+			return keys[0], nil
+		}
+
+		k, err = tw.putNode(level, keys)
+		if err != nil || top {
+			return k, err
+		}
+
+		tw.levels[level] = nil
+		tw.levels[level+1] = append(tw.levels[level+1], k)
+	}
+
+	return k, nil
+}
+
+//PutTree is like Put but instead of writing the key of each chunk it stores
+//the list of keys as a hash tree in the same store and returns the key of
+//the tree's root. The root key is enough to get the file with GetTree
+func PutTree(cr ChunkReader, conf Config) (root K, err error) {
+	return PutTreeContext(context.Background(), cr, conf)
+}
+
+//PutTreeContext is like PutTree but aborts when the context is cancelled or
+//its deadline exceeds
+func PutTreeContext(ctx context.Context, cr ChunkReader, conf Config) (root K, err error) {
+	dst, err := conf.Stores.PutDst()
+	if err != nil {
+		return root, fmt.Errorf("failed to get a store to put chunks in: %v", err)
+	}
+
+	tw := &treeWriter{ctx: ctx, conf: conf, dst: dst}
+	err = PutContext(ctx, cr, tw, conf)
+	if err != nil {
+		return root, err
+	}
+
+	return tw.root()
+}
+
+//treeFrame is a node that is being expanded by the tree reader
+type treeFrame struct {
+	level int
+	keys  []K
+	pos   int
+}
+
+//TreeReader is a key reader that expands the hash tree under a root key
+//depth first, it returns the keys of the file's chunks in order. Nodes are
+//fetched from the stores that chunks are got from when they are reached
+type TreeReader struct {
+	ctx   context.Context
+	conf  Config
+	root  K
+	stack []*treeFrame
+
+	//also return the keys of the tree's nodes, each before the keys it
+	//lists, e.g: to move a file to another store with its tree
+	Nodes bool
+}
+
+//NewTreeReader creates a key reader for the tree with root key 'root'
+func NewTreeReader(ctx context.Context, root K, conf Config) *TreeReader {
+	return &TreeReader{ctx: ctx, conf: conf, root: root}
+}
+
+//Reset the reader to the root of the tree
+func (tr *TreeReader) Reset() {
+	tr.stack = nil
+}
+
+//push fetches and decodes a node onto the stack
+func (tr *TreeReader) push(k K, level int) error {
+	sealed, err := fetchChunk(tr.ctx, tr.conf.Stores.GetSrcs(tr.conf.GetOrder...), k, tr.conf.HedgeDelay)
+	if err != nil {
+		return fmt.Errorf("failed to fetch node '%s' of tree: %v", k, err)
+	}
+
+	node, err := openChunk(tr.conf, k, sealed)
+	if err != nil {
+		return fmt.Errorf("failed to open node '%s' of tree: %v", k, err)
+	}
+
+	nlevel, keys, err := decodeTreeNode(node)
+	if err != nil {
+		return fmt.Errorf("failed to decode node '%s': %v", k, err)
+	}
+
+	if level >= 0 && nlevel != level {
+		return fmt.Errorf("node '%s' is at level %d of the tree, expected %d", k, nlevel, level)
+	}
+
+	tr.stack = append(tr.stack, &treeFrame{level: nlevel, keys: keys})
+	return nil
+}
+
+//Read returns the next key or io.EOF if no more keys are available
+func (tr *TreeReader) Read() (k K, err error) {
+	if tr.stack == nil {
+		tr.stack = []*treeFrame{}
+		if err = tr.push(tr.root, -1); err != nil {
+			return k, err
+		}
+
+		if tr.Nodes {
+			return tr.root, nil
+		}
+	}
+
+	for len(tr.stack) > 0 {
+		top := tr.stack[len(tr.stack)-1]
+		if top.pos >= len(top.keys) {
+			tr.stack = tr.stack[:len(tr.stack)-1]
+			continue
+		}
+
+		k = top.keys[top.pos]
+		top.pos++
+		if top.level == 0 {
+			return k, nil
+		}
+
+		if err = tr.push(k, top.level-1); err != nil {
+			return k, err
+		}
+
+		if tr.Nodes {
+			return k, nil
+		}
+	}
+
+	return k, io.EOF
+}
+
+//GetTree is like Get but reads the keys of the file from the hash tree with
+//root key 'root' that was stored by PutTree
+func GetTree(root K, cw ChunkWriter, conf Config) error {
+	return GetTreeContext(context.Background(), root, cw, conf)
+}
+
+//GetTreeContext is like GetTree but aborts when the context is cancelled
+//or its deadline exceeds
+func GetTreeContext(ctx context.Context, root K, cw ChunkWriter, conf Config) error {
+	return GetContext(ctx, NewTreeReader(ctx, root, conf), cw, conf)
+}
@@ -0,0 +1,94 @@
+package bits_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/chunks"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+)
+
+func TestTree(t *testing.T) {
+	cases := []struct {
+		name   string
+		chunks int
+		nodes  int
+	}{
+		{"empty", 0, 1},
+		{"single_chunk", 1, 1},
+		{"one_full_node", bits.TreeFanOut, 1},
+		{"two_levels", 2*bits.TreeFanOut + 1, 4},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := randb(int64(c.chunks * 64))
+			cr, err := bitschunks.NewFixedChunker(bytes.NewReader(data), 64)
+			if err != nil {
+				t.Fatalf("failed to create chunker: %v", err)
+			}
+
+			store := bitsstore.NewMemStore()
+			conf := withStore(t, defaultConf(t, secret), store)
+			root, err := bits.PutTree(cr, conf)
+			if err != nil {
+				t.Fatalf("failed to put tree: %v", err)
+			}
+
+			if len(root.String()) != 44 {
+				t.Errorf("expected a root key of 44 characters, got: %s", root)
+			}
+
+			//each chunk is random and stored once, besides the nodes of the tree
+			if len(store.Chunks) != c.chunks+c.nodes {
+				t.Errorf("expected %d chunks and %d nodes in store, got %d", c.chunks, c.nodes, len(store.Chunks))
+			}
+
+			output := bytes.NewBuffer(nil)
+			err = bits.GetTree(root, output, withStore(t, defaultConf(t, secret), store))
+			if err != nil {
+				t.Fatalf("failed to get tree: %v", err)
+			}
+
+			if !bytes.Equal(output.Bytes(), data) {
+				t.Errorf("expected output to equal input, input len %d output len %d", len(data), output.Len())
+			}
+
+			tr := bits.NewTreeReader(context.Background(), root, conf)
+			tr.Nodes = true
+			n := 0
+			for {
+				if _, err = tr.Read(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("failed to read tree: %v", err)
+				}
+
+				n++
+			}
+
+			if n != c.chunks+c.nodes {
+				t.Errorf("expected tree reader to return %d keys, got %d", c.chunks+c.nodes, n)
+			}
+		})
+	}
+}
+
+func TestTreeRootIsNoChunk(t *testing.T) {
+	keys := bitskeys.NewMemIterator()
+	conf := withStore(t, defaultConf(t, secret), bitsstore.NewMemStore())
+	err := bits.Put(randBytesInput(bytes.NewReader(randb(1024)), secret), keys, conf)
+	if err != nil {
+		t.Fatalf("failed to put: %v", err)
+	}
+
+	err = bits.GetTree(keys.Keys[0], bytes.NewBuffer(nil), conf)
+	if err == nil || !strings.Contains(err.Error(), "not a node of a tree") {
+		t.Errorf("expected error for a root key that isn't a node, got: %v", err)
+	}
+}
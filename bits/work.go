@@ -0,0 +1,37 @@
+package bits
+
+import "sync"
+
+//workGroup tracks concurrently running work such that it can be waited for
+//when an operation returns early. Once stopped, no new work can be started
+type workGroup struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+}
+
+//Go runs 'f' concurrently and returns true, unless the group was stopped
+func (g *workGroup) Go(f func()) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f()
+	}()
+
+	return true
+}
+
+//StopAndWait prevents new work from being started and waits for all
+//running work to return
+func (g *workGroup) StopAndWait() {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+	g.wg.Wait()
+}
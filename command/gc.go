@@ -0,0 +1,223 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"time"
+
+	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mitchellh/cli"
+)
+
+//GcOpts describes command options
+type GcOpts struct {
+	KeyOpts
+	SecretOpts
+	ConfigOpts
+	ContextOpts
+
+	GcStore     string        `long:"gc-store" default:"local" value-name:"local" description:"name of the configured store from which chunks are deleted that no key list references"`
+	DryRun      bool          `long:"dry-run" description:"only report which chunks would be deleted and how many bytes that would reclaim"`
+	GracePeriod time.Duration `long:"grace-period" default:"1h" value-name:"DURATION" description:"chunks that were put less than this long ago are never deleted, such that chunks of a put whose keys aren't written yet survive. Every unreferenced chunk is deleted if zero"`
+}
+
+//Gc command
+type Gc struct {
+	ui     cli.Ui
+	opts   *GcOpts
+	parser *flags.Parser
+}
+
+//GcFactory returns a factory method for the gc command
+func GcFactory() func() (cmd cli.Command, err error) {
+	cmd := &Gc{
+		ui:   &cli.BasicUi{Reader: os.Stdin, Writer: os.Stderr},
+		opts: &GcOpts{},
+	}
+
+	cmd.parser = flags.NewNamedParser("bits gc", flags.Default)
+	cmd.parser.AddGroup("options", "options", cmd.opts)
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+// Help returns long-form help text that includes the command-line
+// usage, a brief few sentences explaining the function of the command,
+// and the complete list of flags the command accepts.
+func (cmd *Gc) Help() string {
+	buf := bytes.NewBuffer(nil)
+	cmd.parser.WriteHelp(buf)
+	buf2 := bytes.NewBuffer(nil)
+	template.Must(template.New("help").Parse(buf.String())).Execute(buf2, struct {
+		SupportedStores    []string
+		SupportedExchanges []string
+	}{bitsstore.SupportedStores, bitskeys.SupportedKeyFormats})
+
+	return fmt.Sprintf(`
+  %s.
+  takes the paths of one or more key lists as arguments, or '-'
+  for STDIN, and deletes every chunk of the store that none of
+  them references. All key lists are read before anything is
+  deleted, chunks of key lists that are not provided are lost.
+  When chunks are deleted the store records a new gc epoch, the
+  indexes that moves keep of the store go stale and the next
+  move lists the store again.
+  Chunks cannot be deleted from packs, a 'pack' store cannot
+  be garbage collected.
+
+%s`, cmd.Synopsis(), buf2.String())
+}
+
+// Synopsis returns a one-line, short synopsis of the command.
+// This should be less than 50 characters ideally.
+func (cmd *Gc) Synopsis() string {
+	return "deletes chunks that no key list references"
+}
+
+// Run runs the actual command with the given CLI instance and
+// command-line arguments. It returns the exit status when it is
+// finished.
+func (cmd *Gc) Run(args []string) int {
+	a, err := cmd.parser.ParseArgs(args)
+	if err != nil {
+		cmd.ui.Error(err.Error())
+		return 127
+	}
+
+	if err := cmd.DoRun(a); err != nil {
+		cmd.ui.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+//DoRun is called by run and allows an error to be returned
+func (cmd *Gc) DoRun(args []string) (err error) {
+	if len(args) < 1 {
+		return fmt.Errorf("at least one key list must be provided, an empty list of live keys would delete every chunk")
+	}
+
+	secret, err := cmd.opts.SecretOpts.CreateSecret(cmd.ui)
+	if err != nil {
+		return err
+	}
+
+	conf, err := bits.DefaultConf(secret)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := cmd.opts.ConfigOpts.ReadConfig(conf.AEAD, secret)
+	if err != nil {
+		return err
+	}
+
+	conf.Stores, err = CreateStores(cfg, map[string]string{"local": cmd.opts.GcStore})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := CloseStores(conf.Stores); err == nil {
+			err = cerr
+		}
+	}()
+
+	conf.GCGracePeriod = cmd.opts.GracePeriod
+	conf.GCDryRun = cmd.opts.DryRun
+	ctx, cancel := cmd.opts.ContextOpts.CreateContext()
+	defer cancel()
+
+	live := chainKeyReader{}
+	for _, arg := range args {
+		rc := os.Stdin
+		if arg != "-" {
+			rc, err = os.Open(arg)
+			if err != nil {
+				return fmt.Errorf("failed to open key list '%s': %v", arg, err)
+			}
+
+			defer rc.Close()
+		}
+
+		kr, err := cmd.opts.KeyOpts.CreateKeyReader(rc)
+		if err != nil {
+			return err
+		}
+
+		//the nodes of the tree are live along with the chunks of the file
+		tr, err := cmd.opts.KeyOpts.ReadTree(ctx, kr, conf)
+		if err != nil {
+			return err
+		}
+
+		if tr != nil {
+			tr.Nodes = true
+			kr = tr
+		}
+
+		live.readers = append(live.readers, kr)
+	}
+
+	stats, err := bits.GCContext(ctx, &live, conf)
+
+	//indexes of stores without meta are not made stale by the gc epoch
+	_, isRemote := conf.Stores["local"].(bits.RemoteStore)
+	if _, hasMeta := conf.Stores["local"].(bits.MetaStore); isRemote && !hasMeta && stats.Deleted > 0 && !conf.GCDryRun {
+		cmd.ui.Warn(fmt.Sprintf("'%s' cannot record that garbage was collected, move to it with --no-index until its indexes are older than --index-max-age", cmd.opts.GcStore))
+	}
+
+	verb := "deleted"
+	if conf.GCDryRun {
+		verb = "would delete"
+	}
+
+	if err == nil || stats.Deleted > 0 {
+		cmd.ui.Info(fmt.Sprintf("%s %d chunks of '%s', reclaiming %d bytes (%.1f MiB). Kept %d live chunks of %d live keys and %d unreferenced chunks put within %s",
+			verb, stats.Deleted, cmd.opts.GcStore, stats.Reclaimed, float64(stats.Reclaimed)/1024/1024, stats.Kept, stats.Live, stats.Young, conf.GCGracePeriod))
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("gc was aborted: %v", ctx.Err())
+	}
+
+	return err
+}
+
+//chainKeyReader reads the keys of each key reader in turn
+type chainKeyReader struct {
+	readers []bits.KeyReader
+	pos     int
+}
+
+//Reset resets every key reader and starts reading from the first again
+func (c *chainKeyReader) Reset() {
+	for _, kr := range c.readers {
+		kr.Reset()
+	}
+
+	c.pos = 0
+}
+
+//Read the next key of the current key reader or move on to the next one
+func (c *chainKeyReader) Read() (k bits.K, err error) {
+	for c.pos < len(c.readers) {
+		k, err = c.readers[c.pos].Read()
+		if err != io.EOF {
+			return k, err
+		}
+
+		c.pos++
+	}
+
+	return k, io.EOF
+}
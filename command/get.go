@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"os"
+	"time"
 
 	"github.com/advanderveer/libchunk/bits"
 	"github.com/advanderveer/libchunk/bits/chunks"
@@ -20,6 +21,12 @@ type GetOpts struct {
 	KeyOpts
 	ChunkOpts
 	SecretOpts
+	ConfigOpts
+	ContextOpts
+
+	CacheSize  int64         `long:"cache-size" value-name:"MiB" description:"copy chunks that are only found in the second store into the first, the least recently used copies are removed once they take more than this many MiB. The first store must be on a local disk or in memory. Copies are never made by default"`
+	HedgeDelay time.Duration `long:"hedge-delay" value-name:"DURATION" description:"when a store takes longer than this to return a chunk, the next store is asked as well and the first chunk to arrive is used, e.g: '200ms'. Stores are only asked in order when zero"`
+	GetSrcs    []string      `long:"get-src" value-name:"local" description:"name of a configured store to get chunks from, can be provided multiple times in which case the stores are asked in the order given. Defaults to 'local' and 'remote' when these are configured, which are asked in order of their latency"`
 }
 
 //Get command
@@ -130,5 +137,103 @@ func (cmd *Get) DoRun(args []string) (err error) {
 		return err
 	}
 
-	return bits.Get(kr, cw, conf)
+	cfg, err := cmd.opts.ConfigOpts.ReadConfig(conf.AEAD, secret)
+	if err != nil {
+		return err
+	}
+
+	srcs := cmd.opts.GetSrcs
+	if len(srcs) == 0 {
+		for _, name := range []string{"local", "remote"} {
+			if _, ok := cfg.Stores[name]; ok {
+				srcs = append(srcs, name)
+			}
+		}
+	}
+
+	//stores are kept under their own name and asked in the order given
+	roles := map[string]string{}
+	for _, name := range srcs {
+		roles[name] = name
+	}
+
+	stores, err := CreateStores(cfg, roles)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := CloseStores(stores); err == nil {
+			err = cerr
+		}
+	}()
+
+	conf.HedgeDelay = cmd.opts.HedgeDelay
+	conf.GetOrder = cmd.opts.GetSrcs
+	conf.Stores = bits.StoreMap{}
+	for name, s := range stores {
+		conf.Stores[name] = s
+	}
+
+	//chunks of the second store are cached in the first store, which then
+	//asks the second store itself
+	if cmd.opts.CacheSize > 0 {
+		if len(srcs) < 2 || srcs[0] == srcs[1] {
+			return fmt.Errorf("--cache-size requires two different stores to get chunks from, got: %v", srcs)
+		}
+
+		if ls, ok := stores[srcs[0]].(bits.LatencyStore); !ok || ls.Latency() > bits.LatencyDisk {
+			return fmt.Errorf("--cache-size requires the first store ('%s') to be on a local disk or in memory", srcs[0])
+		}
+
+		db, closeDB, err := openStateDB(stores[srcs[0]], secret)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if cerr := closeDB(); err == nil {
+				err = cerr
+			}
+		}()
+
+		cache, err := bitsstore.NewCacheStore(stores[srcs[0]], stores[srcs[1]], db, srcs[0], cmd.opts.CacheSize*1024*1024)
+		if err != nil {
+			return fmt.Errorf("failed to setup cache, get without --cache-size to disable it: %v", err)
+		}
+
+		defer func() {
+			if cerr := cache.Close(); err == nil && cerr != nil {
+				err = fmt.Errorf("failed to close cache: %v", cerr)
+			}
+		}()
+
+		conf.Stores[srcs[0]] = cache
+		delete(conf.Stores, srcs[1])
+		if len(conf.GetOrder) > 0 {
+			conf.GetOrder = append([]string{srcs[0]}, srcs[2:]...)
+		}
+	}
+
+	ctx, cancel := cmd.opts.ContextOpts.CreateContext()
+	defer cancel()
+	tr, err := cmd.opts.KeyOpts.ReadTree(ctx, kr, conf)
+	if err != nil {
+		return err
+	}
+
+	if tr != nil {
+		kr = tr
+	}
+
+	err = bits.GetContext(ctx, kr, cw, conf)
+	if ctx.Err() != nil {
+		return fmt.Errorf("get was aborted: %v", ctx.Err())
+	}
+
+	if bits.IsTransient(err) {
+		return fmt.Errorf("get failed on an error that persisted after retrying but may pass, try again later: %v", err)
+	}
+
+	return err
 }
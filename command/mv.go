@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/advanderveer/libchunk/bits"
+	"github.com/advanderveer/libchunk/bits/index"
 	"github.com/advanderveer/libchunk/bits/keys"
 	"github.com/advanderveer/libchunk/bits/store"
 
+	"github.com/boltdb/bolt"
 	"github.com/jessevdk/go-flags"
 	"github.com/mitchellh/cli"
 )
@@ -18,6 +24,16 @@ import (
 type MvOpts struct {
 	KeyOpts
 	SecretOpts
+	ConfigOpts
+	ContextOpts
+
+	MvSrc string `long:"mv-src" default:"local" value-name:"local" description:"name of the configured store from which chunks are moved"`
+	MvDst string `long:"mv-dst" default:"remote" value-name:"remote" description:"name of the configured store to which chunks are moved"`
+
+	Resume      bool          `long:"resume" description:"skip keys that were already moved by an earlier, interrupted, move with the same transfer id"`
+	NoIndex     bool          `long:"no-index" description:"don't index the destination, every chunk is moved even if the destination holds it already"`
+	IndexMaxAge time.Duration `long:"index-max-age" default:"24h" value-name:"24h" description:"how long the keys that were listed from the destination are trusted, moves skip chunks that the index holds and only list the destination again when it is older"`
+	TransferID  string        `long:"transfer-id" value-name:"ID" description:"identifies the transfer in the journal that records moved keys, defaults to '<mv-src>:<mv-dst>'"`
 }
 
 //Mv command
@@ -60,7 +76,10 @@ func (cmd *Mv) Help() string {
   already present on the remote to prevent itself from sending
   duplicate chunks. There is no remote locking mechanism so
   the index can be out-of-date, in this case some unnessary
-  data transfer will occur but data remains intact.
+  data transfer will occur but data remains intact. Moved keys
+  are recorded in a journal such that an interrupted move can
+  be continued with --resume. A manifest is written unchanged
+  once all of its chunks were moved.
 
 %s`, cmd.Synopsis(), buf2.String())
 }
@@ -90,7 +109,7 @@ func (cmd *Mv) Run(args []string) int {
 }
 
 //DoRun is called by run and allows an error to be returned
-func (cmd *Mv) DoRun(args []string) error {
+func (cmd *Mv) DoRun(args []string) (err error) {
 	secret, err := cmd.opts.SecretOpts.CreateSecret(cmd.ui)
 	if err != nil {
 		return err
@@ -115,21 +134,169 @@ func (cmd *Mv) DoRun(args []string) error {
 
 	defer wc.Close()
 
-	kr, err := cmd.opts.KeyOpts.CreateKeyReader(rc)
+	//a manifest describes the whole file, not the keys that were moved. It
+	//is written unchanged once every chunk of the file was moved
+	var manifest []byte
+	var in io.Reader = rc
+	if cmd.opts.KeyOpts.KeyFormat == "manifest" {
+		manifest, err = ioutil.ReadAll(rc)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %v", err)
+		}
+
+		in = bytes.NewReader(manifest)
+	}
+
+	kr, err := cmd.opts.KeyOpts.CreateKeyReader(in)
 	if err != nil {
 		return err
 	}
 
-	kw, err := cmd.opts.KeyOpts.CreateKeyWriter(wc)
+	var kw bits.KeyWriter = bitskeys.NewMemIterator()
+	if manifest == nil {
+		kw, err = cmd.opts.KeyOpts.CreateKeyWriter(wc)
+		if err != nil {
+			return err
+		}
+	}
+
+	conf, err := bits.DefaultConf(secret)
 	if err != nil {
 		return err
 	}
 
-	conf, err := bits.DefaultConf(secret)
+	cfg, err := cmd.opts.ConfigOpts.ReadConfig(conf.AEAD, secret)
+	if err != nil {
+		return err
+	}
+
+	conf.Stores, err = CreateStores(cfg, map[string]string{
+		"local":  cmd.opts.MvSrc,
+		"remote": cmd.opts.MvDst,
+	})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := CloseStores(conf.Stores); err == nil {
+			err = cerr
+		}
+	}()
+
+	id := cmd.opts.TransferID
+	if id == "" {
+		id = fmt.Sprintf("%s:%s", cmd.opts.MvSrc, cmd.opts.MvDst)
+	}
+
+	db, closeDB, err := openStateDB(conf.Stores["local"], secret)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := closeDB(); err == nil {
+			err = cerr
+		}
+	}()
+
+	journal, err := bitsstore.NewBoltJournal(db, id)
+	if err != nil {
+		return fmt.Errorf("failed to open journal of transfer '%s': %v", id, err)
+	}
+
+	//the index of the destination is kept between moves
+	conf.NoIndex = cmd.opts.NoIndex
+	if !conf.NoIndex {
+		conf.Index, err = bitsindex.NewBoltIndex(db, cmd.opts.MvDst, cmd.opts.IndexMaxAge)
+		if err != nil {
+			return fmt.Errorf("failed to open index of '%s': %v", cmd.opts.MvDst, err)
+		}
+	}
+
+	if !cmd.opts.Resume {
+		err = journal.Clear()
+		if err != nil {
+			return fmt.Errorf("failed to clear journal of transfer '%s': %v", id, err)
+		}
+	}
+
+	conf.Journal = journal
+	ctx, cancel := cmd.opts.ContextOpts.CreateContext()
+	defer cancel()
+	tr, err := cmd.opts.KeyOpts.ReadTree(ctx, kr, conf)
 	if err != nil {
 		return err
 	}
 
-	//@TODO configure dst/src stores
-	return bits.Move(kr, kw, conf)
+	//the nodes of the tree are moved along with the chunks of the file
+	if tr != nil {
+		tr.Nodes = true
+		kr = tr
+	}
+
+	stats, err := bits.MoveContext(ctx, kr, kw, conf)
+	if cmd.opts.Resume {
+		cmd.ui.Info(fmt.Sprintf("resumed transfer '%s': %d of %d keys were moved earlier, %d remained", id, stats.Journaled, stats.Total, stats.Total-stats.Journaled))
+	}
+
+	if stats.Indexed > 0 {
+		cmd.ui.Info(fmt.Sprintf("skipped %d of %d keys because the index showed '%s' holds them", stats.Indexed, stats.Total, cmd.opts.MvDst))
+	}
+
+	if pr, ok := conf.Stores["remote"].(*bitsstore.PackRemote); ok {
+		for id, reason := range pr.BadPacks() {
+			cmd.ui.Warn(fmt.Sprintf("skipped pack '%s' of '%s' that cannot be read, its chunks are moved again: %v", id, cmd.opts.MvDst, reason))
+		}
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("mv was aborted, continue with --resume: %v", ctx.Err())
+	}
+
+	if bits.IsTransient(err) {
+		return fmt.Errorf("mv failed on an error that persisted after retrying but may pass, it can be continued with --resume: %v", err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("mv failed, it can be continued with --resume: %v", err)
+	}
+
+	err = journal.Clear()
+	if err != nil {
+		return fmt.Errorf("failed to clear journal of completed transfer '%s': %v", id, err)
+	}
+
+	if manifest != nil {
+		_, err = wc.Write(manifest)
+		if err != nil {
+			return fmt.Errorf("failed to write manifest: %v", err)
+		}
+
+		return nil
+	}
+
+	return closeKeyWriter(kw)
+}
+
+//openStateDB opens the bolt database that holds the journal of transfers and
+//the index of remotes, it is the database of the store that chunks are moved
+//from when possible. The returned function closes the database if it was
+//opened separately, the database of the store is closed with the store
+func openStateDB(src bits.Store, secret bits.Secret) (db *bolt.DB, closeDB func() error, err error) {
+	if bstore, ok := src.(*bitsstore.BoltStore); ok {
+		return bstore.DB, func() error { return nil }, nil
+	}
+
+	dir, err := SecretDir(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bstore, err := bitsstore.NewBoltStore(filepath.Join(dir, "journal.bolt"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open journal database: %v", err)
+	}
+
+	return bstore.DB, bstore.Close, nil
 }
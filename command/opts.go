@@ -1,26 +1,121 @@
 package command
 
 import (
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/advanderveer/libchunk/bits"
 	"github.com/advanderveer/libchunk/bits/chunks"
+	bitsconf "github.com/advanderveer/libchunk/bits/conf"
 	"github.com/advanderveer/libchunk/bits/keys"
+	"github.com/advanderveer/libchunk/bits/store"
 
 	"github.com/mattn/go-isatty"
 	"github.com/mitchellh/cli"
+	"github.com/mitchellh/go-homedir"
 )
 
 //ChunkOpts configures how we will receive chunks
 type ChunkOpts struct {
-	ChunkerType string `long:"chunker" default:"rabin" value-name:"rabin" description:"method or algorithm used for chunking the raw input data, supports: {{.SupportedChunkers}}"`
+	ChunkerType string `long:"chunker" value-name:"rabin" description:"method or algorithm used for chunking the raw input data, defaults to the chunker that the store was filled with or rabin. Supports: {{.SupportedChunkers}}"`
+	BlockSize   int    `long:"block-size" value-name:"1048576" description:"size in bytes of each chunk when using the fixed chunker, e.g: the block size of a disk image"`
+	ChunkMin    int    `long:"chunk-min" value-name:"BYTES" description:"minimal size of chunks cut by the rabin or fastcdc chunker"`
+	ChunkAvg    int    `long:"chunk-avg" value-name:"BYTES" description:"average size of chunks cut by the rabin or fastcdc chunker, must be a power of two for rabin"`
+	ChunkMax    int    `long:"chunk-max" value-name:"BYTES" description:"maximal size of chunks cut by the rabin or fastcdc chunker"`
 }
 
-//CreateChunkReader will setup a chunk reader based on the cli options
-func (opts *ChunkOpts) CreateChunkReader(r io.Reader, secret bits.Secret) (cr bits.ChunkReader, err error) {
-	cr, err = bitschunks.CreateChunker(opts.ChunkerType, secret, r)
+//ChunkerConfig determines how input is chunked: options take precedence over
+//the configuration file, if neither specifies anything the chunker that store
+//'dst' recorded is used. A warning is shown if it differs from the recorded
+//chunker, as changing it prevents deduplication with earlier versions of files.
+//Size options that don't apply to the chunker that is used return an error
+func (opts *ChunkOpts) ChunkerConfig(cfg *bitsconf.Config, dst bits.Store, ui cli.Ui) (cc bitschunks.ChunkerConfig, err error) {
+	if cfg.Chunker != nil {
+		cc = *cfg.Chunker
+	}
+
+	for _, o := range []struct {
+		v   int
+		dst *int
+	}{
+		{opts.BlockSize, &cc.BlockSize},
+		{opts.ChunkMin, &cc.MinSize},
+		{opts.ChunkAvg, &cc.AvgSize},
+		{opts.ChunkMax, &cc.MaxSize},
+	} {
+		if o.v != 0 {
+			*o.dst = o.v
+		}
+	}
+
+	if opts.ChunkerType != "" {
+		cc.Chunker = opts.ChunkerType
+	}
+
+	recorded, ok, err := bitschunks.ReadChunkerConfig(dst)
+	if err != nil {
+		return cc, err
+	}
+
+	if !ok {
+		cc = cc.Normalize()
+		return cc, opts.checkSizes(cc.Chunker)
+	}
+
+	if cc == (bitschunks.ChunkerConfig{}) {
+		return recorded, nil
+	}
+
+	//sizes alone apply to the chunker the store was filled with
+	if cc.Chunker == "" {
+		cc.Chunker = recorded.Chunker
+	}
+
+	cc = cc.Normalize()
+	err = opts.checkSizes(cc.Chunker)
+	if err != nil {
+		return cc, err
+	}
+
+	if cc != recorded {
+		ui.Warn(fmt.Sprintf("the store was filled with chunker %s, chunks cut by %s will not be deduplicated with them", recorded, cc))
+	}
+
+	return cc, nil
+}
+
+//checkSizes returns an error if a size option is set that chunker 'ctype'
+//doesn't use, the fixed chunker only uses the block size
+func (opts *ChunkOpts) checkSizes(ctype string) error {
+	for _, o := range []struct {
+		name  string
+		v     int
+		fixed bool
+	}{
+		{"block-size", opts.BlockSize, true},
+		{"chunk-min", opts.ChunkMin, false},
+		{"chunk-avg", opts.ChunkAvg, false},
+		{"chunk-max", opts.ChunkMax, false},
+	} {
+		if o.v != 0 && o.fixed != (ctype == "fixed") {
+			return fmt.Errorf("--%s doesn't apply to the %s chunker", o.name, ctype)
+		}
+	}
+
+	return nil
+}
+
+//CreateChunkReader will setup a chunk reader based on the chunker config
+func (opts *ChunkOpts) CreateChunkReader(r io.Reader, secret bits.Secret, cc bitschunks.ChunkerConfig) (cr bits.ChunkReader, err error) {
+	cr, err = bitschunks.CreateChunker(cc.Chunker, secret, r, cc.ChunkerSettings)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create chunker of this type: %v", err)
 	}
@@ -40,7 +135,8 @@ func (opts *ChunkOpts) CreateChunkWriter(w io.Writer) (cw bits.ChunkWriter, err
 
 //KeyOpts configures how keys are handled
 type KeyOpts struct {
-	KeyFormat string `long:"key-fmt" default:"b64-textlines" value-name:"b64-textlines" description:"DOC ME"`
+	KeyFormat string `long:"key-fmt" default:"b64-textlines" value-name:"b64-textlines" description:"format in which key lists are read and written, 'binary' takes 32 bytes per key, 'mem' holds the list in memory such that it can be read again from a pipe and a 'manifest' also describes the sizes of chunks and the length and digest of the file, which get verifies. Supports: {{.SupportedExchanges}}"`
+	Tree      bool   `long:"tree" description:"store the keys of a file as a tree of chunks, such that a single root key identifies the file"`
 }
 
 //CreateKeyWriter will setup a way of writing keys using cli options
@@ -53,6 +149,19 @@ func (opts *KeyOpts) CreateKeyWriter(w io.Writer) (kw bits.KeyWriter, err error)
 	return kw, nil
 }
 
+//closeKeyWriter closes key writers that need to finish their output, e.g:
+//a manifest that ends with the length and digest of the file
+func closeKeyWriter(kw bits.KeyWriter) error {
+	if c, ok := kw.(io.Closer); ok {
+		err := c.Close()
+		if err != nil {
+			return fmt.Errorf("failed to finish writing keys: %v", err)
+		}
+	}
+
+	return nil
+}
+
 //CreateKeyReader will setup a way of writing keys using cli options
 func (opts *KeyOpts) CreateKeyReader(r io.Reader) (kr bits.KeyReader, err error) {
 	kr, err = bitskeys.CreateKeyReader(opts.KeyFormat, r)
@@ -63,6 +172,21 @@ func (opts *KeyOpts) CreateKeyReader(r io.Reader) (kr bits.KeyReader, err error)
 	return kr, nil
 }
 
+//ReadTree reads the root key of a tree from 'kr' if the tree option is set
+//and returns a key reader that expands it, or nil otherwise
+func (opts *KeyOpts) ReadTree(ctx context.Context, kr bits.KeyReader, conf bits.Config) (tr *bits.TreeReader, err error) {
+	if !opts.Tree {
+		return nil, nil
+	}
+
+	root, err := kr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the root key of the tree: %v", err)
+	}
+
+	return bits.NewTreeReader(ctx, root, conf), nil
+}
+
 //SecretOpts documents the secret option used by various commands
 type SecretOpts struct {
 	Secret string `short:"s" long:"secret" description:"secret that will be used to decrypt content chunks, if not specified it will be asked for interactively"`
@@ -110,68 +234,135 @@ func (opt *SecretOpts) CreateSecret(ui cli.Ui) (secret bits.Secret, err error) {
 	return secret, nil
 }
 
-//LocalStoreOpts documents local store option used by various commands
-// type LocalStoreOpts struct {
-// 	StoreDir  string `short:"l" long:"store-dir" description:"directory in which chunks are stored locally, defaults to '.bits' in the user's home directory" value-name:"DIR"`
-// 	StoreType string `long:"store" default:"bolt" description:"specify what type of store is used for keeping local stored chunks, supports: {{.SupportedStores}}" value-name:"bolt"`
-// }
-
-// //CreateStore uses the command line options to open or create a local store
-// //for usage by the bits library based on the configured secret. Errors should should focus on usability
-// func (opts *LocalStoreOpts) CreateStore(secret bits.Secret) (s bits.Store, err error) {
-// 	if opts.StoreDir == "" {
-// 		opts.StoreDir, err = homedir.Dir()
-// 		if err != nil {
-// 			return s, fmt.Errorf("couldnt determine users HOME directory for default --store-dir: %v", err)
-// 		}
-//
-// 		opts.StoreDir = filepath.Join(opts.StoreDir, ".bits")
-// 	}
-//
-// 	//setup secret specific directory
-// 	hash := sha256.Sum256(secret[:])
-// 	storeDir := filepath.Join(opts.StoreDir, fmt.Sprintf("%x", hash))
-// 	err = os.MkdirAll(storeDir, 0700)
-// 	if err != nil {
-// 		return s, fmt.Errorf("failed to create secret specific directory for local storage: %v", err)
-// 	}
-//
-// 	s, err = bitsstore.CreateStore(opts.StoreType, filepath.Join(storeDir, "db.bolt"))
-// 	if err != nil {
-// 		return s, fmt.Errorf("failed to create store with these options: %v", err)
-// 	}
-//
-// 	return s, nil
-// }
-
-// //RemoteOpts configures the remote used by various commands
-// type RemoteOpts struct {
-// 	RemoteType        string `short:"r" long:"remote" default:"s3" description:"the type of remote that will be used for pushing, supports: {{.SupportedRemotes}}" value-name:"s3"`
-// 	S3Scheme          string `long:"s3-scheme" default:"https" value-name:"https" description:"what type of transport scheme should be used, supports 'http' and 'https'"`
-// 	S3Host            string `long:"s3-host" default:"tmp.microfactory.io" value-name:"tmp.microfactory.io" description:"hostname at which the s3 remote resides, defaults to 'tmp.microfactory.io'"`
-// 	S3Prefix          string `long:"s3-prefix" description:"bucket directory in which chunks will be pushed, defaults to the sha2 of the secret"`
-// 	S3AccessKeyID     string `long:"s3-access-key-id" description:"access key to use when using the s3 remote, when empty the s3 remote is assumed to be public"`
-// 	S3SecretAccessKey string `long:"s3-secret-access-key" description:"secret for authorizing with the s3 remote, when empty the s3 remote is assumed to be public"`
-// }
-//
-// //CreateRemote uses the command line options provided by the user to setup
-// //a remote configuration that can be used by the bits library. Errors should
-// //focus on usability.
-// func (opts *RemoteOpts) CreateRemote(secret bits.Secret) (r bits.Remote, err error) {
-// 	if opts.S3Prefix == "" {
-// 		opts.S3Prefix = fmt.Sprintf("%x", sha256.Sum256(secret[:]))
-// 	}
-//
-// 	r, err = bitsremote.CreateRemote(
-// 		opts.RemoteType,
-// 		opts.S3Scheme,
-// 		opts.S3Host,
-// 		opts.S3Prefix,
-// 		opts.S3AccessKeyID,
-// 		opts.S3SecretAccessKey)
-// 	if err != nil {
-// 		return nil, fmt.Errorf("failed to create remote: %v", err)
-// 	}
-//
-// 	return r, nil
-// }
+//ContextOpts configures when long running commands are aborted
+type ContextOpts struct {
+	Timeout time.Duration `long:"timeout" value-name:"DURATION" description:"abort the command when it runs longer then the given duration, e.g: '1h30m'. Commands can always be aborted with an interrupt signal (Ctrl-C)"`
+}
+
+//CreateContext sets up a context that is cancelled when the process receives
+//an interrupt or terminate signal, or when the configured timeout passes. The
+//cancel function should always be called to release resources
+func (opts *ContextOpts) CreateContext() (ctx context.Context, cancel context.CancelFunc) {
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(sigCh)
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+//SecretDir returns a directory in the '.bits' directory of the user's home
+//that is specific to the secret, it is created if it doesn't exist yet
+func SecretDir(secret bits.Secret) (dir string, err error) {
+	dir, err = homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("couldnt determine users HOME directory for local storage: %v", err)
+	}
+
+	hash := sha256.Sum256(secret[:])
+	dir = filepath.Join(dir, ".bits", fmt.Sprintf("%x", hash))
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to create secret specific directory for local storage: %v", err)
+	}
+
+	return dir, nil
+}
+
+//ConfigOpts configures where named stores are described
+type ConfigOpts struct {
+	ConfigPath string `short:"c" long:"config" value-name:"FILE" description:"JSON file that describes named stores and their (encrypted) secrets, when not specified only a 'local' bolt store is available in the '.bits' directory of the user's home"`
+}
+
+//ReadConfig reads the configuration file provided by the user, if no file
+//was provided a default configuration is returned. Errors should focus on usability
+func (opts *ConfigOpts) ReadConfig(aead cipher.AEAD, secret bits.Secret) (cfg *bitsconf.Config, err error) {
+	if opts.ConfigPath == "" {
+		dir, err := SecretDir(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg = bitsconf.NewConfig(aead)
+		cfg.Stores["local"] = &bitsconf.StoreConfig{
+			Kind:            "bolt",
+			BoltStoreConfig: bitsstore.BoltStoreConfig{Path: filepath.Join(dir, "db.bolt")},
+		}
+
+		return cfg, nil
+	}
+
+	f, err := os.Open(opts.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open configuration file '%s': %v", opts.ConfigPath, err)
+	}
+
+	defer f.Close()
+	cfg, err = bitsconf.ReadConfig(f, aead)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file '%s': %v", opts.ConfigPath, err)
+	}
+
+	return cfg, nil
+}
+
+//CreateStores opens each named store in 'roles' and returns them under
+//the role they play, i.e: 'local' or 'remote'. Stores that play multiple
+//roles are only opened once. They should be closed with CloseStores
+func CreateStores(cfg *bitsconf.Config, roles map[string]string) (sm bits.StoreMap, err error) {
+	sm = bits.StoreMap{}
+	opened := map[string]bits.Store{}
+	for role, name := range roles {
+		s, ok := opened[name]
+		if !ok {
+			s, err = cfg.CreateStore(name)
+			if err != nil {
+				CloseStores(sm)
+				return nil, fmt.Errorf("failed to create the '%s' store: %v", role, err)
+			}
+
+			opened[name] = s
+		}
+
+		sm[role] = s
+	}
+
+	return sm, nil
+}
+
+//CloseStores closes each store in 'sm' that holds on to resources, e.g: the
+//database file of a bolt store. Stores that play multiple roles are closed
+//once, the first error is returned
+func CloseStores(sm bits.StoreMap) (err error) {
+	closed := map[io.Closer]struct{}{}
+	for role, s := range sm {
+		c, ok := s.(io.Closer)
+		if !ok {
+			continue
+		}
+
+		if _, ok := closed[c]; ok {
+			continue
+		}
+
+		closed[c] = struct{}{}
+		cerr := c.Close()
+		if cerr != nil && err == nil {
+			err = fmt.Errorf("failed to close the '%s' store: %v", role, cerr)
+		}
+	}
+
+	return err
+}
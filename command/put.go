@@ -20,6 +20,13 @@ type PutOpts struct {
 	SecretOpts
 	ChunkOpts
 	KeyOpts
+	ConfigOpts
+	ContextOpts
+
+	PutDst        string `long:"put-dst" default:"local" value-name:"local" description:"name of the configured store in which chunks are put"`
+	KeyHash       string `long:"key-hash" value-name:"hmac-sha256" description:"how chunk keys are computed, stores remember the key hash they were first used with and refuse others. Defaults to the key hash of the store, which is sha256 for stores that were filled before key hashes were remembered, or hmac-sha256 for an empty store. Supports: {{.SupportedKeyHashes}}"`
+	Deterministic bool   `long:"deterministic" description:"derive each nonce from the chunk instead of generating a random one, such that every machine with the same secret stores a chunk as the same bytes"`
+	Compression   string `long:"compression" default:"none" value-name:"none" description:"compress each chunk before encryption, chunks that don't get smaller are stored uncompressed. Supports: {{.SupportedCompressions}}"`
 }
 
 //Put command
@@ -51,10 +58,12 @@ func (cmd *Put) Help() string {
 	cmd.parser.WriteHelp(buf)
 	buf2 := bytes.NewBuffer(nil)
 	template.Must(template.New("help").Parse(buf.String())).Execute(buf2, struct {
-		SupportedStores    []string
-		SupportedChunkers  []string
-		SupportedExchanges []string
-	}{bitsstore.SupportedStores, bitschunks.SupportedChunkers, bitskeys.SupportedKeyFormats})
+		SupportedStores       []string
+		SupportedChunkers     []string
+		SupportedExchanges    []string
+		SupportedCompressions []string
+		SupportedKeyHashes    []string
+	}{bitsstore.SupportedStores, bitschunks.SupportedChunkers, bitskeys.SupportedKeyFormats, bits.SupportedCompressions, bits.SupportedKeyHashes})
 
 	return fmt.Sprintf(`
   %s. By default
@@ -121,15 +130,96 @@ func (cmd *Put) DoRun(args []string) (err error) {
 		return err
 	}
 
-	cr, err := cmd.opts.ChunkOpts.CreateChunkReader(rc, secret)
+	conf, err := bits.DefaultConf(secret)
 	if err != nil {
 		return err
 	}
 
-	conf, err := bits.DefaultConf(secret)
+	conf.Compression, err = bits.ParseCompression(cmd.opts.Compression)
+	if err != nil {
+		return err
+	}
+
+	if cmd.opts.Deterministic {
+		conf.NonceHash = bits.NewNonceHash(secret)
+	}
+
+	cfg, err := cmd.opts.ConfigOpts.ReadConfig(conf.AEAD, secret)
+	if err != nil {
+		return err
+	}
+
+	conf.Stores, err = CreateStores(cfg, map[string]string{"local": cmd.opts.PutDst})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := CloseStores(conf.Stores); err == nil {
+			err = cerr
+		}
+	}()
+
+	ctx, cancel := cmd.opts.ContextOpts.CreateContext()
+	defer cancel()
+	if cmd.opts.KeyHash != "" {
+		conf.KeyHashMode, err = bits.ParseKeyHashMode(cmd.opts.KeyHash)
+		if err != nil {
+			return err
+		}
+	} else {
+		mode, err := bits.StoreKeyHashMode(ctx, conf.Stores["local"])
+		if err != nil {
+			return err
+		}
+
+		if mode != "" {
+			conf.KeyHashMode = mode
+		}
+	}
+
+	conf.KeyHash, err = bits.NewKeyHash(conf.KeyHashMode, secret)
+	if err != nil {
+		return err
+	}
+
+	cc, err := cmd.opts.ChunkOpts.ChunkerConfig(cfg, conf.Stores["local"], cmd.ui)
+	if err != nil {
+		return err
+	}
+
+	cr, err := cmd.opts.ChunkOpts.CreateChunkReader(rc, secret, cc)
+	if err != nil {
+		return err
+	}
+
+	//record how chunks were cut such that later versions are cut the same
+	err = bitschunks.RecordChunkerConfig(conf.Stores["local"], cc)
+	if err != nil {
+		return err
+	}
+
+	if mw, ok := kw.(*bitskeys.ManifestWriter); ok {
+		mw.Chunker = &cc
+	}
+
+	if cmd.opts.KeyOpts.Tree {
+		var root bits.K
+		root, err = bits.PutTreeContext(ctx, cr, conf)
+		if err == nil {
+			err = kw.Write(root)
+		}
+	} else {
+		err = bits.PutContext(ctx, cr, kw, conf)
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("put was aborted: %v", ctx.Err())
+	}
+
 	if err != nil {
 		return err
 	}
 
-	return bits.Put(cr, kw, conf)
+	return closeKeyWriter(kw)
 }
@@ -21,6 +21,7 @@ func main() {
 		"put": command.PutFactory(),
 		"get": command.GetFactory(),
 		"mv":  command.MvFactory(),
+		"gc":  command.GcFactory(),
 	}
 
 	status, err := c.Run()